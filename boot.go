@@ -12,8 +12,10 @@ import (
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/s3-aws"
 	"github.com/drycc/builder/pkg"
+	"github.com/drycc/builder/pkg/audit"
 	"github.com/drycc/builder/pkg/cleaner"
 	"github.com/drycc/builder/pkg/conf"
+	"github.com/drycc/builder/pkg/githttp"
 	"github.com/drycc/builder/pkg/gitreceive"
 	"github.com/drycc/builder/pkg/healthsrv"
 	"github.com/drycc/builder/pkg/k8s"
@@ -52,6 +54,10 @@ func main() {
 				if err := envconfig.Process(serverConfAppName, cnf); err != nil {
 					return fmt.Errorf("getting config for %s [%s]", serverConfAppName, err)
 				}
+				// builds fail with an invalid images.json, so don't start with one
+				if err := gitreceive.LoadStacks(); err != nil {
+					return fmt.Errorf("error loading stacks (%s)", err)
+				}
 				fs := sys.RealFS()
 				env := sys.RealEnv()
 				pushLock := sshd.NewInMemoryRepositoryLock(cnf.GitLockTimeout())
@@ -67,6 +73,11 @@ func main() {
 					return fmt.Errorf("error creating storage driver (%s)", err)
 				}
 
+				auditor, err := audit.New(cnf.Audit, storageDriver)
+				if err != nil {
+					return fmt.Errorf("error creating auditor (%s)", err)
+				}
+
 				kubeClient, err := k8s.NewInCluster()
 				if err != nil {
 					return fmt.Errorf("error getting kubernetes client [%s]", err)
@@ -81,17 +92,40 @@ func main() {
 				log.Printf("Starting deleted app cleaner")
 				cleanerErrCh := make(chan error)
 				go func() {
-					if err := cleaner.Run(gitHomeDir, kubeClient.CoreV1().Namespaces(), fs, cnf.CleanerPollSleepDuration(), storageDriver); err != nil {
+					if err := cleaner.Run(gitHomeDir, kubeClient.CoreV1().Namespaces(), kubeClient.CoreV1().PersistentVolumeClaims(cnf.PodNamespace), fs, cnf.CleanerPollSleepDuration(), storageDriver); err != nil {
 						cleanerErrCh <- err
 					}
 				}()
 
+				log.Printf("Watching builds")
+				buildWatcher := k8s.NewBuildWatcher(kubeClient, cnf.PodNamespace)
+				go buildWatcher.Run(make(chan struct{}))
+				buildWatcherListener, err := k8s.ListenBuildWatcher(cnf.BuildWatcherSocket)
+				if err != nil {
+					return fmt.Errorf("error listening on %s (%s)", cnf.BuildWatcherSocket, err)
+				}
+				buildWatcherCh := make(chan error)
+				go func() {
+					buildWatcherCh <- buildWatcher.Serve(buildWatcherListener)
+				}()
+
+				log.Printf("Checking stacks")
+				go gitreceive.CheckStacks(cnf.StacksPollSleepDuration())
+
 				log.Printf("Starting SSH server on %s:%d", cnf.SSHHostIP, cnf.SSHHostPort)
 				sshCh := make(chan int)
 				go func() {
-					sshCh <- pkg.RunBuilder(cnf, gitHomeDir, circ, pushLock)
+					sshCh <- pkg.RunBuilder(cnf, gitHomeDir, circ, pushLock, auditor)
 				}()
 
+				gitHTTPCh := make(chan error)
+				if cnf.GitHTTPPort > 0 {
+					log.Printf("Starting git HTTP server on %s:%d", cnf.SSHHostIP, cnf.GitHTTPPort)
+					go func() {
+						gitHTTPCh <- githttp.Serve(cnf, gitHomeDir, pushLock, auditor)
+					}()
+				}
+
 				select {
 				case err := <-healthSrvCh:
 					return fmt.Errorf("error running health server (%s)", err)
@@ -99,6 +133,10 @@ func main() {
 					return fmt.Errorf("unexpected SSH server stop with code %d", i)
 				case err := <-cleanerErrCh:
 					return fmt.Errorf("error running the deleted app cleaner (%s)", err)
+				case err := <-gitHTTPCh:
+					return fmt.Errorf("error running the git HTTP server (%s)", err)
+				case err := <-buildWatcherCh:
+					return fmt.Errorf("error serving the build watcher (%s)", err)
 				}
 			},
 		},
@@ -129,6 +167,37 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:      "tarball-receive",
+			Aliases:   []string{"tr"},
+			Usage:     "Build and release an uploaded source tarball",
+			ArgsUsage: "TARBALL",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				if cmd.Args().Len() != 1 {
+					return fmt.Errorf("expected the path of the tarball to build")
+				}
+				cnf := new(gitreceive.Config)
+				if err := envconfig.Process(gitReceiveConfAppName, cnf); err != nil {
+					return fmt.Errorf("error getting config for %s [%s]", gitReceiveConfAppName, err)
+				}
+				cnf.CheckDurations()
+				env := sys.RealEnv()
+				storageParams, err := conf.GetStorageParams(env)
+				if err != nil {
+					return fmt.Errorf("error getting storage parameters (%s)", err)
+				}
+				var storageDriver storagedriver.StorageDriver
+				storageDriver, err = factory.Create(context.Background(), "s3", storageParams)
+				if err != nil {
+					return fmt.Errorf("error creating storage driver (%s)", err)
+				}
+
+				if err := gitreceive.RunTarball(cnf, env, storageDriver, cmd.Args().First()); err != nil {
+					return fmt.Errorf("error running tarball receive [%s]", err)
+				}
+				return nil
+			},
+		},
 	}
 
 	if err := app.Run(context.Background(), os.Args); err != nil {
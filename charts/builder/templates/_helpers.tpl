@@ -105,6 +105,91 @@ env:
       name: registry-secret
       key: password
 {{- end }}
+{{- if .Values.gitHTTP.enabled }}
+- name: "GIT_HTTP_PORT"
+  value: "8080"
+{{- end }}
+- name: "AUDIT_ENABLED"
+  value: "{{ .Values.audit.enabled }}"
+- name: "AUDIT_STORAGE_ENABLED"
+  value: "{{ .Values.audit.storage }}"
+{{- if (.Values.audit.webhookURL) }}
+- name: "AUDIT_WEBHOOK_URL"
+  value: {{ .Values.audit.webhookURL | quote }}
+{{- end }}
+{{- if (.Values.buildWebhooks.urls) }}
+- name: "BUILD_WEBHOOK_URLS"
+  value: {{ join "," .Values.buildWebhooks.urls | quote }}
+{{- end }}
+{{- if (.Values.buildWebhooks.secret) }}
+- name: "BUILD_WEBHOOK_SECRET"
+  valueFrom:
+    secretKeyRef:
+      name: builder-secret
+      key: build-webhook-secret
+{{- end }}
+{{- if (.Values.forge.targetURL) }}
+- name: "FORGE_TARGET_URL"
+  value: {{ .Values.forge.targetURL | quote }}
+{{- end }}
+{{- if (.Values.forge.urls) }}
+- name: "FORGE_URLS"
+  value: {{ join "," .Values.forge.urls | quote }}
+{{- end }}
+{{- if (.Values.gitCredentials) }}
+- name: "GIT_CREDENTIALS_FILE"
+  value: /var/run/secrets/drycc/builder/git/git-credentials
+{{- end }}
+- name: "BUILDER_POD_CPU_REQUEST"
+  value: {{ .Values.builderPodResources.cpuRequest | quote }}
+- name: "BUILDER_POD_CPU_LIMIT"
+  value: {{ .Values.builderPodResources.cpuLimit | quote }}
+- name: "BUILDER_POD_MEMORY_REQUEST"
+  value: {{ .Values.builderPodResources.memoryRequest | quote }}
+- name: "BUILDER_POD_MEMORY_LIMIT"
+  value: {{ .Values.builderPodResources.memoryLimit | quote }}
+{{- with .Values.builderPodScheduling }}
+{{- if .tolerations }}
+- name: "BUILDER_POD_TOLERATIONS"
+  value: {{ toJson .tolerations | quote }}
+{{- end }}
+{{- if .affinity }}
+- name: "BUILDER_POD_AFFINITY"
+  value: {{ toJson .affinity | quote }}
+{{- end }}
+{{- if .topologySpreadConstraints }}
+- name: "BUILDER_POD_TOPOLOGY_SPREAD_CONSTRAINTS"
+  value: {{ toJson .topologySpreadConstraints | quote }}
+{{- end }}
+{{- if .priorityClassName }}
+- name: "BUILDER_POD_PRIORITY_CLASS_NAME"
+  value: {{ .priorityClassName | quote }}
+{{- end }}
+{{- end }}
+- name: "BUILDER_POD_SECURITY_PROFILE"
+  value: {{ .Values.builderPodSecurity.profile | quote }}
+{{- if .Values.builderPodSecurity.securityContext }}
+- name: "BUILDER_POD_SECURITY_CONTEXT"
+  value: {{ toJson .Values.builderPodSecurity.securityContext | quote }}
+{{- end }}
+{{- if .Values.buildCache.type }}
+- name: "BUILD_CACHE_TYPE"
+  value: {{ .Values.buildCache.type | quote }}
+- name: "BUILD_CACHE_SIZE"
+  value: {{ .Values.buildCache.size | quote }}
+- name: "BUILD_CACHE_STORAGE_CLASS"
+  value: {{ .Values.buildCache.storageClass | quote }}
+{{- end }}
+- name: "BUILD_ENV_GLOBAL"
+  value: "{{ .Values.buildEnvGlobal }}"
+- name: "BUILDER_POD_ACTIVE_DEADLINE_SECONDS"
+  value: "{{ .Values.builderPodActiveDeadlineSeconds }}"
+- name: "BUILDER_POD_BACKOFF_LIMIT"
+  value: "{{ .Values.builderPodBackoffLimit }}"
+{{- if .Values.builderPodAppServiceAccounts }}
+- name: "BUILDER_POD_APP_SERVICE_ACCOUNTS"
+  value: {{ join "," .Values.builderPodAppServiceAccounts | quote }}
+{{- end }}
 {{- if (.Values.builderPodNodeSelector) }}
 - name: BUILDER_POD_NODE_SELECTOR
   value: {{.Values.builderPodNodeSelector}}
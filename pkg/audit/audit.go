@@ -0,0 +1,95 @@
+// Package audit emits structured, durable records of every authentication decision and push
+// handled by the builder.
+//
+// Each record is an Event serialized as a single line of JSON. The schema is versioned by
+// SchemaVersion; fields are only ever added to it, never renamed or removed, so that log
+// pipelines and SIEMs can rely on it.
+package audit
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/drycc/pkg/log"
+	"github.com/google/uuid"
+)
+
+// SchemaVersion is the version of the Event schema.
+const SchemaVersion = "1"
+
+// EventType identifies what an Event records.
+type EventType string
+
+const (
+	// AuthAccepted is emitted when a user's SSH key is accepted by the controller.
+	AuthAccepted EventType = "auth.accepted"
+	// AuthDenied is emitted when a user is refused access, either because the key is unknown or
+	// because the user has no permission on the app being pushed.
+	AuthDenied EventType = "auth.denied"
+	// PushStarted is emitted when an authorized git-receive-pack begins.
+	PushStarted EventType = "push.started"
+	// RefUpdated is emitted by the git-receive hook for every ref update it reads.
+	RefUpdated EventType = "ref.updated"
+	// BuildSucceeded is emitted when the image for a ref was built and released.
+	BuildSucceeded EventType = "build.succeeded"
+	// BuildFailed is emitted when building or releasing a ref failed.
+	BuildFailed EventType = "build.failed"
+	// ReleaseCreated is emitted when the controller has published a new release for an app.
+	ReleaseCreated EventType = "release.created"
+)
+
+// Event is a single audit record.
+type Event struct {
+	SchemaVersion string    `json:"schemaVersion"`
+	ID            string    `json:"id"`
+	Time          time.Time `json:"time"`
+	Type          EventType `json:"type"`
+	User          string    `json:"user,omitempty"`
+	Fingerprint   string    `json:"fingerprint,omitempty"`
+	RemoteAddr    string    `json:"remoteAddr,omitempty"`
+	App           string    `json:"app,omitempty"`
+	Ref           string    `json:"ref,omitempty"`
+	OldRev        string    `json:"oldRev,omitempty"`
+	NewRev        string    `json:"newRev,omitempty"`
+	Release       int       `json:"release,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Sink is a destination for serialized audit events.
+type Sink interface {
+	// Write stores a single serialized event.
+	Write(evt Event, data []byte) error
+}
+
+// Auditor fans audit events out to a set of sinks. A nil *Auditor is valid and discards
+// everything, so callers never need to check whether auditing is enabled.
+type Auditor struct {
+	sinks []Sink
+	now   func() time.Time
+}
+
+// NewAuditor returns an Auditor writing to sinks.
+func NewAuditor(sinks ...Sink) *Auditor {
+	return &Auditor{sinks: sinks, now: time.Now}
+}
+
+// Emit completes evt with its schema version, id and timestamp and writes it to every sink.
+// Failing sinks are logged but never returned, so that auditing can't break a push.
+func (a *Auditor) Emit(evt Event) {
+	if a == nil || len(a.sinks) == 0 {
+		return
+	}
+	evt.SchemaVersion = SchemaVersion
+	evt.ID = uuid.New().String()
+	evt.Time = a.now().UTC()
+	data, err := json.Marshal(evt)
+	if err != nil {
+		log.Err("marshaling audit event %s (%s)", evt.Type, err)
+		return
+	}
+	for _, sink := range a.sinks {
+		if err := sink.Write(evt, data); err != nil {
+			log.Err("writing audit event %s (%s)", evt.Type, err)
+		}
+	}
+}
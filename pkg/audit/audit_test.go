@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingSink struct{}
+
+func (failingSink) Write(Event, []byte) error {
+	return errors.New("test error")
+}
+
+func TestEmit(t *testing.T) {
+	buf := new(bytes.Buffer)
+	auditor := NewAuditor(failingSink{}, NewWriterSink(buf))
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	auditor.now = func() time.Time { return now }
+
+	auditor.Emit(Event{Type: AuthAccepted, User: "drycc", Fingerprint: "aa:bb", RemoteAddr: "10.0.0.1"})
+	auditor.Emit(Event{Type: ReleaseCreated, App: "demo", Release: 3})
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Equal(t, len(lines), 2, "number of events")
+
+	evt := Event{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &evt))
+	assert.Equal(t, evt.SchemaVersion, SchemaVersion, "schema version")
+	assert.Equal(t, evt.Type, AuthAccepted, "type")
+	assert.Equal(t, evt.User, "drycc", "user")
+	assert.Equal(t, evt.Fingerprint, "aa:bb", "fingerprint")
+	assert.Equal(t, evt.RemoteAddr, "10.0.0.1", "remote address")
+	assert.Equal(t, evt.Time, now, "time")
+	assert.NotEqual(t, evt.ID, "", "id")
+
+	// empty fields must be left out of the schema
+	assert.False(t, strings.Contains(lines[1], "fingerprint"), "unexpected fingerprint field")
+	assert.True(t, strings.Contains(lines[1], `"release":3`), "missing release field")
+}
+
+func TestEmitNilAuditor(_ *testing.T) {
+	var auditor *Auditor
+	auditor.Emit(Event{Type: PushStarted})
+}
+
+func TestNew(t *testing.T) {
+	auditor, err := New(Config{Enabled: false, Output: Stdout}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, auditor, "disabled auditor")
+
+	auditor, err = New(Config{Enabled: true, Output: Stdout, WebhookURL: "http://localhost"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, len(auditor.sinks), 2, "number of sinks")
+
+	_, err = New(Config{Enabled: true, Output: "/does/not/exist/audit.log"}, nil)
+	assert.NotNil(t, err, "unwritable output")
+}
@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+const (
+	// Stdout is the Output value that writes events to the standard output of the process.
+	Stdout = "stdout"
+	// ContainerStdout is the standard output of the builder container. Processes whose own
+	// stdout is relayed to the git client, like the git-receive hook, write there instead.
+	ContainerStdout = "/proc/1/fd/1"
+)
+
+// Config is the envconfig (http://github.com/kelseyhightower/envconfig) compatible struct for
+// the audit subsystem.
+type Config struct {
+	Enabled            bool   `envconfig:"AUDIT_ENABLED" default:"true"`
+	Output             string `envconfig:"AUDIT_OUTPUT" default:"stdout"`
+	StorageEnabled     bool   `envconfig:"AUDIT_STORAGE_ENABLED" default:"false"`
+	StoragePrefix      string `envconfig:"AUDIT_STORAGE_PREFIX" default:"audit"`
+	WebhookURL         string `envconfig:"AUDIT_WEBHOOK_URL" default:""`
+	WebhookTimeoutMSec int    `envconfig:"AUDIT_WEBHOOK_TIMEOUT" default:"5000"`
+}
+
+// WebhookTimeout returns c.WebhookTimeoutMSec as a time.Duration.
+func (c Config) WebhookTimeout() time.Duration {
+	return time.Duration(c.WebhookTimeoutMSec) * time.Millisecond
+}
+
+// New creates an Auditor from c. storageDriver is only used if c.StorageEnabled is set. Returns
+// a nil *Auditor, which discards every event, if auditing is disabled.
+func New(c Config, storageDriver storagedriver.StorageDriver) (*Auditor, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	var sinks []Sink
+	switch c.Output {
+	case "":
+	case Stdout:
+		sinks = append(sinks, NewWriterSink(os.Stdout))
+	default:
+		f, err := os.OpenFile(c.Output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("opening audit output %s (%s)", c.Output, err)
+		}
+		sinks = append(sinks, NewWriterSink(f))
+	}
+	if c.StorageEnabled {
+		sinks = append(sinks, NewStorageSink(storageDriver, c.StoragePrefix))
+	}
+	if c.WebhookURL != "" {
+		sinks = append(sinks, NewWebhookSink(c.WebhookURL, c.WebhookTimeout()))
+	}
+	return NewAuditor(sinks...), nil
+}
@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// WriterSink writes every event as a line of JSON to an io.Writer.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink returns a WriterSink writing to w.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// Write is the Sink interface implementation.
+func (s *WriterSink) Write(_ Event, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(append(data, '\n'))
+	return err
+}
+
+// StorageSink stores every event as its own object under a prefix in object storage. Objects are
+// grouped by day, and named so that listing a day returns its events in order.
+type StorageSink struct {
+	driver storagedriver.StorageDriver
+	prefix string
+}
+
+// NewStorageSink returns a StorageSink writing under prefix with driver.
+func NewStorageSink(driver storagedriver.StorageDriver, prefix string) *StorageSink {
+	return &StorageSink{driver: driver, prefix: prefix}
+}
+
+// Key returns the object key evt is stored under.
+func (s *StorageSink) Key(evt Event) string {
+	return path.Join(
+		"/",
+		s.prefix,
+		evt.Time.Format("2006-01-02"),
+		fmt.Sprintf("%s-%s.json", evt.Time.Format("150405.000000000"), evt.ID),
+	)
+}
+
+// Write is the Sink interface implementation.
+func (s *StorageSink) Write(evt Event, data []byte) error {
+	key := s.Key(evt)
+	if err := s.driver.PutContent(context.Background(), key, data); err != nil {
+		return fmt.Errorf("uploading audit event to %s (%s)", key, err)
+	}
+	return nil
+}
+
+// WebhookSink POSTs every event as JSON to a URL.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink returns a WebhookSink posting to url, giving up on a request after timeout.
+func NewWebhookSink(url string, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// Write is the Sink interface implementation.
+func (s *WebhookSink) Write(_ Event, data []byte) error {
+	res, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("posting audit event to %s (%s)", s.url, err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("posting audit event to %s returned %s", s.url, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageSink(t *testing.T) {
+	storageDriver, err := factory.Create(context.Background(), "inmemory", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewStorageSink(storageDriver, "audit")
+	evt := Event{
+		ID:   "8a1d4c2e",
+		Time: time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC),
+		Type: PushStarted,
+	}
+	key := sink.Key(evt)
+	assert.Equal(t, key, "/audit/2026-01-02/030405.000000006-8a1d4c2e.json", "object key")
+
+	assert.Nil(t, sink.Write(evt, []byte("{}")))
+	data, err := storageDriver.GetContent(context.Background(), key)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), "{}", "stored event")
+}
+
+func TestWebhookSink(t *testing.T) {
+	var body []byte
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		contentType = r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, time.Second)
+	assert.Nil(t, sink.Write(Event{}, []byte(`{"type":"push.started"}`)))
+	assert.Equal(t, string(body), `{"type":"push.started"}`, "posted body")
+	assert.Equal(t, contentType, "application/json", "content type")
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	assert.NotNil(t, NewWebhookSink(failing.URL, time.Second).Write(Event{}, []byte("{}")), "failing webhook")
+}
@@ -7,6 +7,7 @@ package pkg
 import (
 	"fmt"
 
+	"github.com/drycc/builder/pkg/audit"
 	"github.com/drycc/builder/pkg/sshd"
 	"github.com/drycc/pkg/log"
 )
@@ -25,15 +26,21 @@ const (
 // Git.
 //
 // Run returns on of the Status* status code constants.
-func RunBuilder(cnf *sshd.Config, gitHomeDir string, sshServerCircuit *sshd.Circuit, pushLock sshd.RepositoryLock) int {
+func RunBuilder(
+	cnf *sshd.Config,
+	gitHomeDir string,
+	sshServerCircuit *sshd.Circuit,
+	pushLock sshd.RepositoryLock,
+	auditor *audit.Auditor,
+) int {
 	address := fmt.Sprintf("%s:%d", cnf.SSHHostIP, cnf.SSHHostPort)
-	cfg, err := sshd.Configure(cnf)
+	cfg, err := sshd.Configure(cnf, auditor)
 	if err != nil {
 		log.Err("SSH server configuration failed: %s", err)
 		return StatusLocalError
 	}
 	receivetype := "gitreceive"
-	if err := sshd.Serve(cfg, sshServerCircuit, gitHomeDir, pushLock, address, receivetype); err != nil {
+	if err := sshd.Serve(cfg, sshServerCircuit, gitHomeDir, pushLock, auditor, address, receivetype); err != nil {
 		log.Err("SSH server failed: %s", err)
 		return StatusLocalError
 	}
@@ -17,6 +17,7 @@ import (
 	"github.com/drycc/builder/pkg/sys"
 	"github.com/drycc/pkg/log"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -91,7 +92,10 @@ func deleteFromStorage(app string, storageDriver storagedriver.StorageDriver) er
 	}
 
 	// regex needs prepended / to match output of List()
-	gitRegex, err := regexp.Compile(`^/` + fmt.Sprintf(gitreceive.GitKeyPattern, app, ".{8}") + "$")
+	gitRegex, err := regexp.Compile(`^/(` + fmt.Sprintf(gitreceive.GitKeyPattern, app, ".{8}") + "|" +
+		fmt.Sprintf(gitreceive.TarballKeyPattern, app, ".{8}") + "|" +
+		fmt.Sprintf(gitreceive.SourceTreeKeyPattern, app) + "|" +
+		fmt.Sprintf(gitreceive.BuildCacheKeyPattern, app, "[^/]+") + ")$")
 	if err != nil {
 		return err
 	}
@@ -107,9 +111,18 @@ func deleteFromStorage(app string, storageDriver storagedriver.StorageDriver) er
 	return nil
 }
 
+// deleteBuildCache deletes the PersistentVolumeClaim caching the builds of app, if any.
+func deleteBuildCache(app string, pvcDeleter k8s.PersistentVolumeClaimDeleter) error {
+	err := pvcDeleter.Delete(context.Background(), gitreceive.BuildCacheClaimName(app), metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
 // Run starts the deleted app cleaner. Every pollSleepDuration, it compares the result of nsLister.List with the directories in the top level of gitHome on the local file system.
 // On any error, it uses log messages to output a human readable description of what happened.
-func Run(gitHome string, nsLister k8s.NamespaceLister, fs sys.FS, pollSleepDuration time.Duration, storageDriver storagedriver.StorageDriver) error {
+func Run(gitHome string, nsLister k8s.NamespaceLister, pvcDeleter k8s.PersistentVolumeClaimDeleter, fs sys.FS, pollSleepDuration time.Duration, storageDriver storagedriver.StorageDriver) error {
 	for {
 		nsList, err := nsLister.List(context.TODO(), metav1.ListOptions{})
 		if err != nil {
@@ -135,6 +148,9 @@ func Run(gitHome string, nsLister k8s.NamespaceLister, fs sys.FS, pollSleepDurat
 			if err := deleteFromStorage(appToDelete, storageDriver); err != nil {
 				log.Err("Cleaner error removing object store files for deleted app %s (%s)", appToDelete, err)
 			}
+			if err := deleteBuildCache(appToDelete, pvcDeleter); err != nil {
+				log.Err("Cleaner error removing the build cache for deleted app %s (%s)", appToDelete, err)
+			}
 		}
 
 		time.Sleep(pollSleepDuration)
@@ -1,6 +1,8 @@
 package cleaner
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
@@ -8,6 +10,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -38,13 +41,17 @@ func TestLocalDirs(t *testing.T) {
 	assert.Equal(t, err, nil)
 
 	expectedPackages := map[string]int{
+		"audit":      1,
 		"cleaner":    1,
 		"conf":       1,
 		"controller": 1,
+		"forge":      1,
 		"git":        1,
+		"githttp":    1,
 		"gitreceive": 1,
 		"healthsrv":  1,
 		"k8s":        1,
+		"notify":     1,
 		"sshd":       1,
 		"storage":    1,
 		"sys":        1,
@@ -80,3 +87,25 @@ func TestStripSuffixes(t *testing.T) {
 		assert.False(t, strings.HasSuffix(str, dotGitSuffix), "string %s has suffix %s", str, dotGitSuffix)
 	}
 }
+
+type fakePVCDeleter struct {
+	deleted []string
+	err     error
+}
+
+func (f *fakePVCDeleter) Delete(_ context.Context, name string, _ metav1.DeleteOptions) error {
+	f.deleted = append(f.deleted, name)
+	return f.err
+}
+
+func TestDeleteBuildCache(t *testing.T) {
+	pvcDeleter := &fakePVCDeleter{}
+	assert.NoError(t, deleteBuildCache("app1", pvcDeleter))
+	assert.Equal(t, []string{"imagebuild-cache-app1"}, pvcDeleter.deleted)
+
+	pvcDeleter.err = apierrors.NewNotFound(v1.Resource("persistentvolumeclaims"), "imagebuild-cache-app2")
+	assert.NoError(t, deleteBuildCache("app2", pvcDeleter), "app without build cache")
+
+	pvcDeleter.err = errors.New("delete error")
+	assert.Error(t, deleteBuildCache("app3", pvcDeleter))
+}
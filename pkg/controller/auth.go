@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+
+	drycc "github.com/drycc/controller-sdk-go"
+	"github.com/drycc/controller-sdk-go/api"
+	"github.com/drycc/controller-sdk-go/hooks"
+)
+
+// NewForToken creates a new SDK client acting as the user owning a Drycc API token, rather than
+// as the builder.
+func NewForToken(controllerURL, token string) (*drycc.Client, error) {
+	client, err := drycc.New(true, controllerURL, token)
+	if err != nil {
+		return client, err
+	}
+	client.UserAgent = "drycc-builder"
+	return client, nil
+}
+
+// Whoami returns the name of the user owning the token of c.
+func Whoami(c *drycc.Client) (string, error) {
+	res, err := c.Request("GET", "/v2/auth/whoami/", nil)
+	if CheckAPICompat(c, err) != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	user := api.User{}
+	if err := json.NewDecoder(res.Body).Decode(&user); err != nil {
+		return "", fmt.Errorf("decoding the current user (%s)", err)
+	}
+	return user.Username, nil
+}
+
+// CanPushApp returns nil if username may push to app, c being the client of the builder. Being
+// able to view app isn't enough: the controller only gives the config of app to builds of the
+// users who may push to it, whichever way they push.
+func CanPushApp(c *drycc.Client, username, app string) error {
+	_, err := hooks.GetAppConfig(c, username, app)
+	return CheckAPICompat(c, err)
+}
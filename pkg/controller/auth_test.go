@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	drycc "github.com/drycc/controller-sdk-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newControllerStandIn(token string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("DRYCC_API_VERSION", drycc.APIVersion)
+		if r.URL.Path == "/v2/hooks/config/" {
+			// drycc may view the shared app, but not push to it
+			var push struct {
+				User string `json:"receive_user"`
+				App  string `json:"receive_repo"`
+			}
+			json.NewDecoder(r.Body).Decode(&push)
+			if push.User != "drycc" || push.App != "demo" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"owner":"drycc","app":"demo","values":[]}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "token "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/auth/whoami/":
+			w.Write([]byte(`{"id":1,"username":"drycc","email":"drycc@example.com"}`))
+		case "/v2/apps/demo/", "/v2/apps/shared/":
+			w.Write([]byte(`{"id":"demo","owner":"drycc"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestWhoami(t *testing.T) {
+	server := newControllerStandIn("secret")
+	defer server.Close()
+
+	client, err := NewForToken(server.URL, "secret")
+	assert.Nil(t, err)
+	username, err := Whoami(client)
+	assert.Nil(t, err)
+	assert.Equal(t, username, "drycc", "username")
+
+	client, err = NewForToken(server.URL, "wrong")
+	assert.Nil(t, err)
+	_, err = Whoami(client)
+	assert.NotNil(t, err, "invalid token")
+}
+
+func TestCanPushApp(t *testing.T) {
+	server := newControllerStandIn("secret")
+	defer server.Close()
+
+	client, err := drycc.New(true, server.URL, "")
+	assert.Nil(t, err)
+	assert.Nil(t, CanPushApp(client, "drycc", "demo"))
+	assert.NotNil(t, CanPushApp(client, "drycc", "other"), "app without access")
+
+	userClient, err := NewForToken(server.URL, "secret")
+	assert.Nil(t, err)
+	res, err := userClient.Request("GET", "/v2/apps/shared/", nil)
+	if assert.Nil(t, err, "the user can view the app") {
+		res.Body.Close()
+	}
+	assert.NotNil(t, CanPushApp(client, "drycc", "shared"), "app the user may only view")
+}
@@ -0,0 +1,132 @@
+// Package forge reports the status of builds back to the Git forge (GitHub, Gitea, ...) hosting
+// the source of an app, as commit statuses on the built sha.
+package forge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// State is the state of a commit status.
+type State string
+
+const (
+	// Pending means the build is in progress.
+	Pending State = "pending"
+	// Success means the build was released.
+	Success State = "success"
+	// Failure means the build failed.
+	Failure State = "failure"
+)
+
+// Status is a commit status as understood by both GitHub and Gitea.
+type Status struct {
+	State       State  `json:"state"`
+	TargetURL   string `json:"target_url,omitempty"`
+	Description string `json:"description,omitempty"`
+	Context     string `json:"context,omitempty"`
+}
+
+// Client posts commit statuses to a single repository of a forge.
+type Client interface {
+	CreateStatus(sha string, status Status) error
+}
+
+// Repository is the per-app metadata describing where the source of an app is hosted. It is read
+// from the app's controller config values, see RepositoryFromValues.
+type Repository struct {
+	// Type is the kind of forge, one of the keys of Factories.
+	Type string
+	// URL is the base URL of the forge API. Defaults to the public API of the forge type, if any.
+	URL string
+	// Name is the full name of the repository, i.e. "owner/repo".
+	Name string
+	// Token is the API token used to post statuses.
+	Token string
+}
+
+const (
+	typeKey       = "DRYCC_FORGE_TYPE"
+	urlKey        = "DRYCC_FORGE_URL"
+	repositoryKey = "DRYCC_FORGE_REPOSITORY"
+	tokenKey      = "DRYCC_FORGE_TOKEN"
+)
+
+// RepositoryFromValues reads the Repository of an app from its config values. Returns nil, nil if
+// the app has no repository configured. The URL of the repository must be one of allowedURLs,
+// since app owners set it, and the builder posts to it.
+func RepositoryFromValues(values map[string]string, allowedURLs []string) (*Repository, error) {
+	name := values[repositoryKey]
+	if name == "" {
+		return nil, nil
+	}
+	if parts := strings.Split(name, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("%s must be in the owner/repo format, got %s", repositoryKey, name)
+	}
+	repo := &Repository{
+		Type:  values[typeKey],
+		URL:   strings.TrimSuffix(values[urlKey], "/"),
+		Name:  name,
+		Token: values[tokenKey],
+	}
+	if repo.Type == "" {
+		repo.Type = "github"
+	}
+	if repo.URL != "" && !slices.Contains(allowedURLs, repo.URL) {
+		return nil, fmt.Errorf("%s %s isn't one of the forge URLs allowed by the builder", urlKey, repo.URL)
+	}
+	if repo.Token == "" {
+		return nil, fmt.Errorf("%s is required to report statuses to %s", tokenKey, name)
+	}
+	return repo, nil
+}
+
+// Factory creates the Client for a repository.
+type Factory func(repo Repository, httpClient *http.Client) (Client, error)
+
+// Factories holds the supported forge types. Other forges can be plugged in by adding to it.
+var Factories = map[string]Factory{
+	"github": newGitHub,
+	"gitea":  newGitea,
+}
+
+// NewClient returns the Client for repo, giving up on requests after timeout.
+func NewClient(repo Repository, timeout time.Duration) (Client, error) {
+	factory, ok := Factories[repo.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported forge type %s", repo.Type)
+	}
+	return factory(repo, &http.Client{Timeout: timeout})
+}
+
+// postStatus posts status as JSON to url, with the given Authorization header.
+func postStatus(httpClient *http.Client, url, authorization string, status Status) error {
+	body, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "drycc-builder")
+	req.Header.Set("Authorization", authorization)
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting commit status to %s (%s)", url, err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("posting commit status to %s returned %s", url, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package forge
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type standInRequest struct {
+	Path          string
+	Authorization string
+	Status        Status
+}
+
+// standIn is a local HTTP stand-in for the commit status endpoints of a forge.
+type standIn struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []standInRequest
+}
+
+func newStandIn() *standIn {
+	s := &standIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := Status{}
+		if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, standInRequest{
+			Path:          r.URL.Path,
+			Authorization: r.Header.Get("Authorization"),
+			Status:        status,
+		})
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	return s
+}
+
+func TestRepositoryFromValues(t *testing.T) {
+	allowed := Config{URLs: "https://gitea.example.com/, https://git.example.com"}.AllowedURLs()
+	assert.Equal(t, []string{"https://gitea.example.com", "https://git.example.com"}, allowed)
+
+	repo, err := RepositoryFromValues(map[string]string{}, allowed)
+	assert.Nil(t, err)
+	assert.Nil(t, repo, "repository without metadata")
+
+	repo, err = RepositoryFromValues(map[string]string{
+		"DRYCC_FORGE_REPOSITORY": "drycc/builder",
+		"DRYCC_FORGE_TOKEN":      "token",
+	}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, *repo, Repository{Type: "github", Name: "drycc/builder", Token: "token"}, "repository")
+
+	gitea := map[string]string{
+		"DRYCC_FORGE_TYPE":       "gitea",
+		"DRYCC_FORGE_URL":        "https://gitea.example.com/",
+		"DRYCC_FORGE_REPOSITORY": "drycc/builder",
+		"DRYCC_FORGE_TOKEN":      "token",
+	}
+	repo, err = RepositoryFromValues(gitea, allowed)
+	assert.Nil(t, err)
+	assert.Equal(t, repo.URL, "https://gitea.example.com", "trailing slash is trimmed")
+
+	gitea["DRYCC_FORGE_URL"] = "http://controller.drycc.svc"
+	_, err = RepositoryFromValues(gitea, allowed)
+	assert.EqualError(t, err, "DRYCC_FORGE_URL http://controller.drycc.svc isn't one of the forge URLs allowed by the builder")
+	_, err = RepositoryFromValues(gitea, nil)
+	assert.NotNil(t, err, "no forge URLs allowed")
+
+	_, err = RepositoryFromValues(map[string]string{"DRYCC_FORGE_REPOSITORY": "builder", "DRYCC_FORGE_TOKEN": "token"}, nil)
+	assert.NotNil(t, err, "malformed repository name")
+	_, err = RepositoryFromValues(map[string]string{"DRYCC_FORGE_REPOSITORY": "drycc/builder"}, nil)
+	assert.NotNil(t, err, "missing token")
+}
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient(Repository{Type: "svn"}, time.Second)
+	assert.NotNil(t, err, "unsupported forge")
+	_, err = NewClient(Repository{Type: "gitea", Name: "drycc/builder"}, time.Second)
+	assert.NotNil(t, err, "gitea without URL")
+}
+
+func TestGitHubCreateStatus(t *testing.T) {
+	server := newStandIn()
+	defer server.Close()
+
+	client, err := NewClient(Repository{Type: "github", URL: server.URL, Name: "drycc/builder", Token: "token"}, time.Second)
+	assert.Nil(t, err)
+	status := Status{State: Pending, TargetURL: "https://drycc.example.com", Description: "Build queued", Context: "drycc/builder"}
+	assert.Nil(t, client.CreateStatus("0462cef5812ce31fe12f25596ff68dc614c708af", status))
+
+	assert.Equal(t, len(server.requests), 1, "number of requests")
+	assert.Equal(t, server.requests[0].Path, "/repos/drycc/builder/statuses/0462cef5812ce31fe12f25596ff68dc614c708af", "path")
+	assert.Equal(t, server.requests[0].Authorization, "Bearer token", "authorization")
+	assert.Equal(t, server.requests[0].Status, status, "status")
+}
+
+func TestGiteaCreateStatus(t *testing.T) {
+	server := newStandIn()
+	defer server.Close()
+
+	client, err := NewClient(Repository{Type: "gitea", URL: server.URL, Name: "drycc/builder", Token: "token"}, time.Second)
+	assert.Nil(t, err)
+	status := Status{State: Failure, Description: "Build failed", Context: "drycc/builder"}
+	assert.Nil(t, client.CreateStatus("0462cef5812ce31fe12f25596ff68dc614c708af", status))
+
+	assert.Equal(t, len(server.requests), 1, "number of requests")
+	assert.Equal(t, server.requests[0].Path, "/api/v1/repos/drycc/builder/statuses/0462cef5812ce31fe12f25596ff68dc614c708af", "path")
+	assert.Equal(t, server.requests[0].Authorization, "token token", "authorization")
+	assert.Equal(t, server.requests[0].Status, status, "status")
+}
+
+func TestCreateStatusFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Repository{Type: "github", URL: server.URL, Name: "drycc/builder", Token: "bad"}, time.Second)
+	assert.Nil(t, err)
+	assert.NotNil(t, client.CreateStatus("0462cef5812ce31fe12f25596ff68dc614c708af", Status{State: Pending}))
+}
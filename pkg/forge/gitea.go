@@ -0,0 +1,28 @@
+package forge
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// gitea is the Client for Gitea and its forks, like Forgejo.
+//
+// See https://docs.gitea.com/api
+type gitea struct {
+	repo       Repository
+	httpClient *http.Client
+}
+
+func newGitea(repo Repository, httpClient *http.Client) (Client, error) {
+	if repo.URL == "" {
+		return nil, errors.New("gitea requires the URL of its API")
+	}
+	return &gitea{repo: repo, httpClient: httpClient}, nil
+}
+
+// CreateStatus is the Client interface implementation.
+func (g *gitea) CreateStatus(sha string, status Status) error {
+	url := fmt.Sprintf("%s/api/v1/repos/%s/statuses/%s", g.repo.URL, g.repo.Name, sha)
+	return postStatus(g.httpClient, url, "token "+g.repo.Token, status)
+}
@@ -0,0 +1,29 @@
+package forge
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const gitHubAPIURL = "https://api.github.com"
+
+// gitHub is the Client for GitHub and GitHub Enterprise.
+//
+// See https://docs.github.com/en/rest/commits/statuses
+type gitHub struct {
+	repo       Repository
+	httpClient *http.Client
+}
+
+func newGitHub(repo Repository, httpClient *http.Client) (Client, error) {
+	if repo.URL == "" {
+		repo.URL = gitHubAPIURL
+	}
+	return &gitHub{repo: repo, httpClient: httpClient}, nil
+}
+
+// CreateStatus is the Client interface implementation.
+func (g *gitHub) CreateStatus(sha string, status Status) error {
+	url := fmt.Sprintf("%s/repos/%s/statuses/%s", g.repo.URL, g.repo.Name, sha)
+	return postStatus(g.httpClient, url, "Bearer "+g.repo.Token, status)
+}
@@ -0,0 +1,95 @@
+package forge
+
+import (
+	"strings"
+	"time"
+
+	"github.com/drycc/builder/pkg/notify"
+)
+
+// Config is the envconfig (http://github.com/kelseyhightower/envconfig) compatible struct for
+// reporting commit statuses.
+type Config struct {
+	// TargetURL is the link to the build log attached to every status. The {app}, {sha} and {job}
+	// placeholders are replaced with the app name, the full sha and the imagebuild job name.
+	TargetURL     string `envconfig:"FORGE_TARGET_URL" default:""`
+	StatusContext string `envconfig:"FORGE_STATUS_CONTEXT" default:"drycc/builder"`
+	TimeoutMSec   int    `envconfig:"FORGE_TIMEOUT" default:"5000"`
+	// URLs are the comma separated base URLs of the forge APIs which apps may report statuses to
+	// with DRYCC_FORGE_URL. The statuses of the apps without DRYCC_FORGE_URL go to the public API
+	// of their forge type.
+	URLs string `envconfig:"FORGE_URLS" default:""`
+}
+
+// Timeout returns c.TimeoutMSec as a time.Duration.
+func (c Config) Timeout() time.Duration {
+	return time.Duration(c.TimeoutMSec) * time.Millisecond
+}
+
+// AllowedURLs returns c.URLs as a slice, without trailing slashes.
+func (c Config) AllowedURLs() []string {
+	var urls []string
+	for _, url := range strings.Split(c.URLs, ",") {
+		if url = strings.TrimSuffix(strings.TrimSpace(url), "/"); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// StatusHook is a notify.Hook that mirrors the lifecycle of builds as commit statuses.
+type StatusHook struct {
+	client Client
+	conf   Config
+}
+
+// NewStatusHook returns a StatusHook posting statuses with client.
+func NewStatusHook(client Client, conf Config) *StatusHook {
+	return &StatusHook{client: client, conf: conf}
+}
+
+// Notify is the notify.Hook interface implementation.
+func (h *StatusHook) Notify(n notify.Notification) error {
+	status := Status{
+		Context:   h.conf.StatusContext,
+		TargetURL: h.targetURL(n),
+	}
+	switch n.Stage {
+	case notify.Queued:
+		status.State, status.Description = Pending, "Build queued"
+	case notify.Started:
+		status.State, status.Description = Pending, "Build started"
+	case notify.Succeeded:
+		status.State, status.Description = Pending, "Build succeeded, releasing"
+	case notify.Released:
+		status.State, status.Description = Success, "Released"
+	case notify.Failed:
+		status.State, status.Description = Failure, "Build failed"
+	default:
+		return nil
+	}
+	return h.client.CreateStatus(n.Sha, status)
+}
+
+func (h *StatusHook) targetURL(n notify.Notification) string {
+	return strings.NewReplacer("{app}", n.App, "{sha}", n.Sha, "{job}", n.Job).Replace(h.conf.TargetURL)
+}
+
+// FakeCreateStatusCall represents a single call to CreateStatus on the FakeClient.
+type FakeCreateStatusCall struct {
+	Sha    string
+	Status Status
+}
+
+// FakeClient is a Client that records statuses instead of posting them, so you can unit test
+// your code.
+type FakeClient struct {
+	Err   error
+	Calls []FakeCreateStatusCall
+}
+
+// CreateStatus is the Client interface implementation.
+func (f *FakeClient) CreateStatus(sha string, status Status) error {
+	f.Calls = append(f.Calls, FakeCreateStatusCall{Sha: sha, Status: status})
+	return f.Err
+}
@@ -0,0 +1,41 @@
+package forge
+
+import (
+	"testing"
+
+	"github.com/drycc/builder/pkg/notify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusHook(t *testing.T) {
+	client := &FakeClient{}
+	hook := NewStatusHook(client, Config{
+		TargetURL:     "https://drycc.example.com/apps/{app}/builds/{job}?sha={sha}",
+		StatusContext: "drycc/builder",
+	})
+	n := notify.Notification{App: "demo", Sha: "0462cef5812ce31fe12f25596ff68dc614c708af", Job: "imagebuild-demo"}
+	stages := []struct {
+		stage notify.Stage
+		state State
+	}{
+		{notify.Queued, Pending},
+		{notify.Started, Pending},
+		{notify.Succeeded, Pending},
+		{notify.Released, Success},
+		{notify.Failed, Failure},
+	}
+	for i, caze := range stages {
+		n.Stage = caze.stage
+		assert.Nil(t, hook.Notify(n))
+		assert.Equal(t, len(client.Calls), i+1, "number of statuses")
+		call := client.Calls[i]
+		assert.Equal(t, call.Sha, n.Sha, "sha")
+		assert.Equal(t, call.Status.State, caze.state, "state for %s", caze.stage)
+		assert.Equal(t, call.Status.Context, "drycc/builder", "context")
+		assert.Equal(t, call.Status.TargetURL, "https://drycc.example.com/apps/demo/builds/imagebuild-demo?sha="+n.Sha, "target url")
+	}
+
+	n.Stage = notify.Stage("build.unknown")
+	assert.Nil(t, hook.Notify(n))
+	assert.Equal(t, len(client.Calls), len(stages), "unknown stages are ignored")
+}
@@ -0,0 +1,127 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CheckoutOptions tells Checkout what to resolve beyond the files of a commit itself, which are
+// all a push brings along.
+type CheckoutOptions struct {
+	// Submodules checks out the submodules recursively, from the URLs in .gitmodules.
+	Submodules bool
+	// LFSURL is the Git LFS server to fetch LFS objects from. Pointer files are left as they are if
+	// it's empty.
+	LFSURL string
+	// CredentialsFile is a git-credential-store file holding the credentials of the submodule and
+	// LFS remotes. See https://git-scm.com/docs/git-credential-store
+	CredentialsFile string
+}
+
+// Checkout checks out rev of the repo at repoDir, resolving what opts asks for, into a new
+// temporary directory. It's up to the caller to remove it. The output of git is written to out.
+func Checkout(repoDir, rev string, opts CheckoutOptions, out io.Writer) (string, error) {
+	workDir, err := os.MkdirTemp("", "checkout")
+	if err != nil {
+		return "", fmt.Errorf("unable to create the checkout directory (%s)", err)
+	}
+
+	var config []string
+	if opts.CredentialsFile != "" {
+		config = append(config, "-c", fmt.Sprintf("credential.helper=store --file=%s", opts.CredentialsFile))
+	}
+	if opts.LFSURL != "" {
+		config = append(config, "-c", fmt.Sprintf("lfs.url=%s", opts.LFSURL))
+	}
+	git := func(args ...string) error {
+		cmd := repoCmd(workDir, "git", append(config, args...)...)
+		// LFS objects are pulled on purpose below, never while checking out, and remotes must
+		// fail rather than prompt for missing credentials
+		cmd.Env = append(os.Environ(), "GIT_LFS_SKIP_SMUDGE=1", "GIT_TERMINAL_PROMPT=0")
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git %s failed (%s)", args[0], err)
+		}
+		return nil
+	}
+
+	steps := [][]string{
+		{"clone", "--quiet", "--shared", "--no-checkout", repoDir, "."},
+		{"checkout", "--quiet", "--detach", rev},
+	}
+	if opts.Submodules {
+		steps = append(steps, []string{"submodule", "update", "--quiet", "--init", "--recursive"})
+	}
+	if opts.LFSURL != "" {
+		steps = append(steps, []string{"lfs", "pull"})
+	}
+	for _, step := range steps {
+		if err := git(step...); err != nil {
+			os.RemoveAll(workDir)
+			return "", err
+		}
+	}
+	return workDir, nil
+}
+
+// ExportIgnored returns the paths under the dir directory of the checkout at workDir which have
+// the export-ignore attribute, and which git archive would thus leave out. Paths are relative to
+// dir, which is relative to workDir. See https://git-scm.com/docs/gitattributes#_creating_an_archive
+func ExportIgnored(workDir, dir string) (map[string]bool, error) {
+	var paths bytes.Buffer
+	root := filepath.Join(workDir, dir)
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == ".git" {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name, err := filepath.Rel(workDir, file)
+		if err != nil || file == root {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if d.IsDir() {
+			// directory patterns only match paths ending with a slash
+			name += "/"
+		}
+		paths.WriteString(name)
+		paths.WriteByte(0)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var errbuff bytes.Buffer
+	cmd := repoCmd(workDir, "git", "check-attr", "-z", "--stdin", "export-ignore")
+	cmd.Stdin = &paths
+	cmd.Stderr = &errbuff
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git check-attr failed: %s (%s)", errbuff.Bytes(), err)
+	}
+	// the output is made of <path> NUL <attribute> NUL <value> NUL triplets
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	ignored := make(map[string]bool)
+	for i := 0; i+2 < len(fields); i += 3 {
+		if fields[i+2] == "set" {
+			name := strings.TrimSuffix(fields[i], "/")
+			if dir != "" {
+				name = strings.TrimPrefix(name, filepath.ToSlash(dir)+"/")
+			}
+			ignored[name] = true
+		}
+	}
+	return ignored, nil
+}
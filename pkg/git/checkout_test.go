@@ -0,0 +1,74 @@
+package git
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckout(t *testing.T) {
+	// submodules of the test are local repos, which git refuses to clone by default
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "protocol.file.allow")
+	t.Setenv("GIT_CONFIG_VALUE_0", "always")
+
+	libDir := commitFiles(t, map[string]string{"lib.go": "package lib"})
+	repoDir := commitFiles(t, map[string]string{"Procfile": "web: ./server"})
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=drycc", "-c", "user.email=drycc@example.com"}, args...)...)
+		cmd.Dir = repoDir
+		out, err := cmd.CombinedOutput()
+		assert.Nil(t, err, "git %s: %s", strings.Join(args, " "), out)
+	}
+	git("submodule", "add", "--quiet", libDir, "lib")
+	git("commit", "--quiet", "--message", "add lib")
+
+	workDir, err := Checkout(repoDir, "HEAD", CheckoutOptions{}, io.Discard)
+	assert.Nil(t, err)
+	defer os.RemoveAll(workDir)
+	_, err = os.Stat(filepath.Join(workDir, "Procfile"))
+	assert.Nil(t, err, "checked out Procfile")
+	_, err = os.Stat(filepath.Join(workDir, "lib", "lib.go"))
+	assert.True(t, os.IsNotExist(err), "submodules must not be checked out unless asked")
+
+	workDir, err = Checkout(repoDir, "HEAD", CheckoutOptions{Submodules: true}, io.Discard)
+	assert.Nil(t, err)
+	defer os.RemoveAll(workDir)
+	lib, err := os.ReadFile(filepath.Join(workDir, "lib", "lib.go"))
+	assert.Nil(t, err)
+	assert.Equal(t, string(lib), "package lib", "checked out submodule")
+
+	_, err = Checkout(repoDir, "0000000000000000000000000000000000000000", CheckoutOptions{}, io.Discard)
+	assert.NotNil(t, err, "missing revision")
+}
+
+func TestExportIgnored(t *testing.T) {
+	repoDir := commitFiles(t, map[string]string{
+		".gitattributes":     "docs/ export-ignore\n*.md export-ignore\n",
+		"Procfile":           "web: ./server",
+		"README.md":          "readme",
+		"docs/index.html":    "docs",
+		"web/Procfile":       "web: ./server",
+		"web/CHANGELOG.md":   "changes",
+		"web/.gitattributes": "*.log export-ignore\n",
+		"web/debug.log":      "debug",
+	})
+
+	ignored, err := ExportIgnored(repoDir, "")
+	assert.Nil(t, err)
+	assert.Equal(t, ignored, map[string]bool{
+		"README.md":        true,
+		"docs":             true,
+		"web/CHANGELOG.md": true,
+		"web/debug.log":    true,
+	})
+
+	ignored, err = ExportIgnored(repoDir, "web")
+	assert.Nil(t, err)
+	assert.Equal(t, ignored, map[string]bool{"CHANGELOG.md": true, "debug.log": true})
+}
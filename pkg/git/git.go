@@ -56,16 +56,7 @@ func Receive(
 	}
 	repoPath := filepath.Join(gitHome, repo)
 	defer os.RemoveAll(repoPath)
-	log.Info("creating repo directory %s", repoPath)
-	if _, err := createRepo(repoPath); err != nil {
-		err = fmt.Errorf("did not create new repo (%s)", err)
-
-		return err
-	}
-
-	log.Info("writing pre-receive hook under %s", repoPath)
-	if err := createPreReceiveHook(gitHome, repoPath); err != nil {
-		err = fmt.Errorf("did not write pre-receive hook (%s)", err)
+	if err := prepareRepo(gitHome, repoPath); err != nil {
 		return err
 	}
 
@@ -75,14 +66,7 @@ func Receive(
 	var errbuff bytes.Buffer
 
 	cmd.Dir = gitHome
-	cmd.Env = []string{
-		fmt.Sprintf("RECEIVE_USER=%s", username),
-		fmt.Sprintf("RECEIVE_REPO=%s", repo),
-		fmt.Sprintf("RECEIVE_FINGERPRINT=%s", fingerprint),
-		fmt.Sprintf("SSH_ORIGINAL_COMMAND=%s '%s'", operation, repo),
-		fmt.Sprintf("SSH_CONNECTION=%s", conndata),
-	}
-	cmd.Env = append(cmd.Env, os.Environ()...)
+	cmd.Env = receiveEnv(repo, operation, fingerprint, username, conndata)
 
 	log.Debug("Working Dir: %s", cmd.Dir)
 	log.Debug("Environment: %s", strings.Join(cmd.Env, ","))
@@ -119,6 +103,32 @@ func Receive(
 	return nil
 }
 
+// prepareRepo creates the bare repo at repoPath along with its pre-receive hook.
+func prepareRepo(gitHome, repoPath string) error {
+	log.Info("creating repo directory %s", repoPath)
+	if _, err := createRepo(repoPath); err != nil {
+		return fmt.Errorf("did not create new repo (%s)", err)
+	}
+
+	log.Info("writing pre-receive hook under %s", repoPath)
+	if err := createPreReceiveHook(gitHome, repoPath); err != nil {
+		return fmt.Errorf("did not write pre-receive hook (%s)", err)
+	}
+	return nil
+}
+
+// receiveEnv returns the environment the pre-receive hook expects to run operation on repo.
+func receiveEnv(repo, operation, fingerprint, username, conndata string) []string {
+	env := []string{
+		fmt.Sprintf("RECEIVE_USER=%s", username),
+		fmt.Sprintf("RECEIVE_REPO=%s", repo),
+		fmt.Sprintf("RECEIVE_FINGERPRINT=%s", fingerprint),
+		fmt.Sprintf("SSH_ORIGINAL_COMMAND=%s '%s'", operation, repo),
+		fmt.Sprintf("SSH_CONNECTION=%s", conndata),
+	}
+	return append(env, os.Environ()...)
+}
+
 var createLock sync.Mutex
 
 // createRepo creates a new Git repo if it is not present already.
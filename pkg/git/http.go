@@ -0,0 +1,96 @@
+package git
+
+// This file contains the Git-specific portions of the smart HTTP transport.
+//
+// See https://git-scm.com/docs/http-protocol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/drycc/pkg/log"
+)
+
+const receivePack = "git-receive-pack"
+
+// pktLine writes a line following the pkt-line git protocol.
+func pktLine(w io.Writer, s string) error {
+	_, err := fmt.Fprintf(w, "%04x%s", len(s)+4, s)
+	return err
+}
+
+// AdvertiseRefs writes the smart HTTP ref advertisement of git-receive-pack to w.
+//
+// Every push is received into a new, empty repo, so the advertisement is the one of an empty
+// repo. It's produced from a throwaway repo to leave alone any push in progress for the same app.
+func AdvertiseRefs(w io.Writer) error {
+	repoPath, err := os.MkdirTemp("", "advertise")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(repoPath)
+	if out, err := repoCmd(repoPath, "git", "init", "--bare").CombinedOutput(); err != nil {
+		return fmt.Errorf("git init failed (%s): %s", err, out)
+	}
+
+	var out, errbuff bytes.Buffer
+	cmd := repoCmd(repoPath, "git", "receive-pack", "--stateless-rpc", "--advertise-refs", ".")
+	cmd.Stdout = &out
+	cmd.Stderr = &errbuff
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to advertise refs: %s (%s)", errbuff.Bytes(), err)
+	}
+
+	if err := pktLine(w, fmt.Sprintf("# service=%s\n", receivePack)); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "0000"); err != nil {
+		return err
+	}
+	_, err = out.WriteTo(w)
+	return err
+}
+
+// ReceiveStateless receives a Git repo through a stateless git-receive-pack, as used by the smart
+// HTTP transport. The request is read from r and the result, including the output of the
+// pre-receive hook, is written to w.
+func ReceiveStateless(
+	repo, gitHome string,
+	r io.Reader,
+	w io.Writer,
+	fingerprint, username, conndata string,
+) error {
+	log.Info("receiving git repo name: %s over http, user: %s", repo, username)
+
+	repoPath := filepath.Join(gitHome, repo)
+	defer os.RemoveAll(repoPath)
+	if err := prepareRepo(gitHome, repoPath); err != nil {
+		return err
+	}
+
+	var errbuff bytes.Buffer
+	cmd := repoCmd(gitHome, "git", "receive-pack", "--stateless-rpc", repo)
+	cmd.Env = receiveEnv(repo, receivePack, fingerprint, username, conndata)
+	cmd.Stdin = r
+	cmd.Stdout = w
+	cmd.Stderr = &errbuff
+	log.Info(strings.Join(cmd.Args, " "))
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run git receive-pack: %s (%s)", errbuff.Bytes(), err)
+	}
+	log.Info("Deploy complete.")
+	return nil
+}
+
+// repoCmd returns exec.Command(first, others...) with its current working directory repoDir
+func repoCmd(repoDir, first string, others ...string) *exec.Cmd {
+	cmd := exec.Command(first, others...)
+	cmd.Dir = repoDir
+	return cmd
+}
@@ -0,0 +1,24 @@
+package git
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPktLine(t *testing.T) {
+	b := new(bytes.Buffer)
+	assert.Nil(t, pktLine(b, "# service=git-receive-pack\n"))
+	assert.Equal(t, b.String(), "001f# service=git-receive-pack\n", "pkt-line")
+}
+
+func TestAdvertiseRefs(t *testing.T) {
+	b := new(bytes.Buffer)
+	assert.Nil(t, AdvertiseRefs(b))
+	out := b.String()
+	assert.True(t, strings.HasPrefix(out, "001f# service=git-receive-pack\n0000"), "service header in %q", out)
+	assert.True(t, strings.Contains(out, "capabilities^{}"), "empty repo advertisement in %q", out)
+	assert.True(t, strings.HasSuffix(out, "0000"), "flush packet in %q", out)
+}
@@ -0,0 +1,264 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tree is a read-only fs.FS of the files of a revision of a repo. Files are read straight from
+// the object database with git ls-tree and git cat-file, so nothing is checked out to disk.
+type Tree struct {
+	repoDir string
+	rev     string
+}
+
+// NewTree returns the Tree of rev in the repo at repoDir.
+func NewTree(repoDir, rev string) Tree {
+	return Tree{repoDir: repoDir, rev: rev}
+}
+
+// ResolveTree returns the id of the tree of the dir directory of rev in the repo at repoDir. dir
+// is relative to the top of the repo, "" for the top itself.
+func ResolveTree(repoDir, rev, dir string) (string, error) {
+	// <rev>:<path> names the object at path in rev, the root tree for an empty path
+	object := fmt.Sprintf("%s:%s", rev, dir)
+	var errbuff bytes.Buffer
+	cmd := repoCmd(repoDir, "git", "cat-file", "-t", object)
+	cmd.Stderr = &errbuff
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s not found in %s: %s (%s)", dir, rev, errbuff.Bytes(), err)
+	}
+	if objectType := strings.TrimSpace(string(out)); objectType != "tree" {
+		return "", fmt.Errorf("%s is a %s in %s, not a directory", dir, objectType, rev)
+	}
+	out, err = repoCmd(repoDir, "git", "rev-parse", "--verify", object).Output()
+	if err != nil {
+		return "", fmt.Errorf("resolving %s (%s)", object, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Open is the fs.FS interface implementation.
+func (t Tree) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &treeDir{entry: treeEntry{name: ".", mode: fs.ModeDir | 0o755}, tree: t, path: name}, nil
+	}
+	entry, resolved, err := t.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	// the file keeps the name it's opened with, like os.Open does through symlinks
+	entry.name = path.Base(name)
+	if entry.IsDir() {
+		return &treeDir{entry: entry, tree: t, path: resolved}, nil
+	}
+	var content []byte
+	if entry.objectType == "blob" {
+		if content, err = t.catBlob(entry.object); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+	return &treeFile{entry: entry, r: bytes.NewReader(content)}, nil
+}
+
+// maxSymlinks is the number of symlinks resolving a path may follow, which ends symlink loops.
+const maxSymlinks = 40
+
+// resolve returns the entry at name and the path it resolves to, following the symlinks of name
+// and of its parent directories. Symlinks pointing out of t, e.g. to /etc/passwd or to ../Procfile
+// from the top of t, aren't followed, since t is all a build may read.
+func (t Tree) resolve(name string) (treeEntry, string, error) {
+	resolved := "."
+	rest := strings.Split(name, "/")
+	for links := 0; len(rest) > 0; {
+		next := path.Join(resolved, rest[0])
+		rest = rest[1:]
+		entries, err := t.lsTree(next)
+		if err != nil {
+			return treeEntry{}, "", err
+		}
+		if len(entries) != 1 {
+			return treeEntry{}, "", fs.ErrNotExist
+		}
+		entry := entries[0]
+		if entry.mode&fs.ModeSymlink == 0 {
+			if len(rest) == 0 {
+				return entry, next, nil
+			}
+			if !entry.IsDir() {
+				return treeEntry{}, "", fs.ErrNotExist
+			}
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return treeEntry{}, "", fmt.Errorf("too many levels of symlinks resolving %s", name)
+		}
+		content, err := t.catBlob(entry.object)
+		if err != nil {
+			return treeEntry{}, "", err
+		}
+		target := string(content)
+		joined := path.Join(path.Dir(next), target)
+		if path.IsAbs(target) || !fs.ValidPath(joined) {
+			return treeEntry{}, "", fmt.Errorf("symlink %s points out of the source tree, to %s", next, target)
+		}
+		// resolve the target from the top, then what's left of name
+		resolved = "."
+		if joined != "." {
+			rest = append(strings.Split(joined, "/"), rest...)
+		}
+	}
+	// name is a symlink to the top of t
+	return treeEntry{name: ".", mode: fs.ModeDir | 0o755}, ".", nil
+}
+
+// catBlob returns the content of the blob object.
+func (t Tree) catBlob(object string) ([]byte, error) {
+	var errbuff bytes.Buffer
+	cmd := repoCmd(t.repoDir, "git", "cat-file", "blob", object)
+	cmd.Stderr = &errbuff
+	content, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s (%s)", errbuff.Bytes(), err)
+	}
+	return content, nil
+}
+
+// lsTree returns the entries git ls-tree lists for pathspec at t.rev.
+func (t Tree) lsTree(pathspec string) ([]treeEntry, error) {
+	var errbuff bytes.Buffer
+	cmd := repoCmd(t.repoDir, "git", "ls-tree", "-z", "--long", "--full-tree", t.rev, "--", pathspec)
+	cmd.Stderr = &errbuff
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-tree %s %s failed: %s (%s)", t.rev, pathspec, errbuff.Bytes(), err)
+	}
+	var entries []treeEntry
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		if line == "" {
+			continue
+		}
+		entry, err := parseTreeEntry(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parseTreeEntry parses a line of git ls-tree --long output:
+//
+//	<mode> SP <type> SP <object> SP <size> TAB <path>
+func parseTreeEntry(line string) (treeEntry, error) {
+	meta, name, ok := strings.Cut(line, "\t")
+	fields := strings.Fields(meta)
+	if !ok || len(fields) != 4 {
+		return treeEntry{}, fmt.Errorf("malformed git ls-tree line [%s]", line)
+	}
+	entry := treeEntry{name: path.Base(name), objectType: fields[1], object: fields[2]}
+	switch fields[0] {
+	case "040000":
+		entry.mode = fs.ModeDir | 0o755
+	case "100755":
+		entry.mode = 0o755
+	case "120000":
+		entry.mode = fs.ModeSymlink | 0o777
+	case "160000":
+		// a submodule, whose commit isn't in this repo
+		entry.mode = fs.ModeIrregular
+	default:
+		entry.mode = 0o644
+	}
+	if fields[3] != "-" {
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return treeEntry{}, fmt.Errorf("malformed git ls-tree line [%s] (%s)", line, err)
+		}
+		entry.size = size
+	}
+	return entry, nil
+}
+
+// treeEntry is an entry of a Tree. It implements fs.FileInfo.
+type treeEntry struct {
+	name       string
+	mode       fs.FileMode
+	size       int64
+	objectType string
+	object     string
+}
+
+func (e treeEntry) Name() string       { return e.name }
+func (e treeEntry) Size() int64        { return e.size }
+func (e treeEntry) Mode() fs.FileMode  { return e.mode }
+func (e treeEntry) ModTime() time.Time { return time.Time{} }
+func (e treeEntry) IsDir() bool        { return e.mode.IsDir() }
+func (e treeEntry) Sys() any           { return nil }
+
+// treeFile is a file opened from a Tree.
+type treeFile struct {
+	entry treeEntry
+	r     *bytes.Reader
+}
+
+func (f *treeFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f *treeFile) Read(p []byte) (int, error) { return f.r.Read(p) }
+func (f *treeFile) Close() error               { return nil }
+
+// treeDir is a directory opened from a Tree. Its entries are listed on the first ReadDir.
+type treeDir struct {
+	entry   treeEntry
+	tree    Tree
+	path    string
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (d *treeDir) Stat() (fs.FileInfo, error) { return d.entry, nil }
+func (d *treeDir) Close() error               { return nil }
+
+func (d *treeDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: fs.ErrInvalid}
+}
+
+// ReadDir is the fs.ReadDirFile interface implementation.
+func (d *treeDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		pathspec := d.path + "/"
+		if d.path == "." {
+			pathspec = "."
+		}
+		entries, err := d.tree.lsTree(pathspec)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.path, Err: err}
+		}
+		for _, entry := range entries {
+			d.entries = append(d.entries, fs.FileInfoToDirEntry(entry))
+		}
+		d.listed = true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
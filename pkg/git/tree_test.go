@@ -0,0 +1,144 @@
+package git
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// commitFiles commits files, a map of paths to contents, to a new repo and returns the repo
+// directory.
+func commitFiles(t *testing.T, files map[string]string) string {
+	repoDir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=drycc", "-c", "user.email=drycc@example.com"}, args...)...)
+		cmd.Dir = repoDir
+		out, err := cmd.CombinedOutput()
+		assert.Nil(t, err, "git %s: %s", strings.Join(args, " "), out)
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "--quiet")
+	for name, content := range files {
+		path := filepath.Join(repoDir, filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	git("add", "--all")
+	git("commit", "--quiet", "--message", "initial commit")
+	return repoDir
+}
+
+func TestTree(t *testing.T) {
+	repoDir := commitFiles(t, map[string]string{
+		"Procfile":            "web: ./server",
+		".drycc/config.yaml":  "config",
+		"src/main.go":         "package main",
+		"src/app/Dockerfile":  "FROM scratch",
+		"name with spaces.md": "spaces",
+	})
+	tree := NewTree(repoDir, "HEAD")
+
+	assert.Nil(t, fstest.TestFS(tree, "Procfile", ".drycc/config.yaml", "src/main.go", "src/app/Dockerfile", "name with spaces.md"))
+
+	procfile, err := fs.ReadFile(tree, "Procfile")
+	assert.Nil(t, err)
+	assert.Equal(t, string(procfile), "web: ./server", "Procfile")
+
+	info, err := fs.Stat(tree, ".drycc")
+	assert.Nil(t, err)
+	assert.True(t, info.IsDir(), ".drycc is a directory")
+
+	_, err = fs.Stat(tree, "Dockerfile")
+	assert.True(t, os.IsNotExist(err), "missing Dockerfile error %v", err)
+
+	_, err = NewTree(repoDir, "0000000000000000000000000000000000000000").Open("Procfile")
+	assert.NotNil(t, err, "missing revision")
+}
+
+func TestTreeSymlinks(t *testing.T) {
+	repoDir := commitFiles(t, map[string]string{
+		"conf/Procfile":    "web: ./server",
+		"conf/config.yaml": "config",
+	})
+	for link, target := range map[string]string{
+		"Procfile":     "conf/Procfile",
+		".drycc":       "conf",
+		"src/Procfile": "../Procfile",
+		"top":          ".",
+		"passwd":       "/etc/passwd",
+		"escape":       "../outside",
+		"loop":         "loop",
+	} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(repoDir, filepath.Dir(link)), 0o755))
+		assert.Nil(t, os.Symlink(target, filepath.Join(repoDir, link)))
+	}
+	assert.Nil(t, exec.Command("git", "-C", repoDir, "add", "--all").Run())
+	cmd := exec.Command("git", "-c", "user.name=drycc", "-c", "user.email=drycc@example.com", "commit", "--quiet", "--message", "symlinks")
+	cmd.Dir = repoDir
+	out, err := cmd.CombinedOutput()
+	assert.Nil(t, err, "%s", out)
+	tree := NewTree(repoDir, "HEAD")
+
+	for _, name := range []string{"Procfile", "src/Procfile", "top/Procfile", "top/.drycc/Procfile"} {
+		content, err := fs.ReadFile(tree, name)
+		assert.Nil(t, err, name)
+		assert.Equal(t, "web: ./server", string(content), name)
+	}
+	config, err := fs.ReadFile(tree, ".drycc/config.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, "config", string(config), "file in a symlinked directory")
+	info, err := fs.Stat(tree, ".drycc")
+	assert.Nil(t, err)
+	assert.True(t, info.IsDir(), ".drycc links to a directory")
+	assert.Equal(t, ".drycc", info.Name())
+	entries, err := fs.ReadDir(tree, ".drycc")
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2, "entries of the linked directory")
+
+	_, err = fs.ReadFile(tree, "passwd")
+	assert.ErrorContains(t, err, "symlink passwd points out of the source tree, to /etc/passwd")
+	_, err = fs.ReadFile(tree, "escape")
+	assert.ErrorContains(t, err, "symlink escape points out of the source tree, to ../outside")
+	_, err = fs.ReadFile(tree, "loop")
+	assert.ErrorContains(t, err, "too many levels of symlinks")
+}
+
+func TestParseTreeEntry(t *testing.T) {
+	entry, err := parseTreeEntry("100755 blob 8baef1b4abc478178b004d62031cf7fe6db6f903     12\tbin/run")
+	assert.Nil(t, err)
+	assert.Equal(t, entry.Name(), "run", "name")
+	assert.Equal(t, entry.Mode(), fs.FileMode(0o755), "mode")
+	assert.Equal(t, entry.Size(), int64(12), "size")
+
+	entry, err = parseTreeEntry("040000 tree 8baef1b4abc478178b004d62031cf7fe6db6f903       -\tbin")
+	assert.Nil(t, err)
+	assert.True(t, entry.IsDir(), "tree entry is a directory")
+
+	_, err = parseTreeEntry("malformed")
+	assert.NotNil(t, err, "malformed line")
+}
+
+func TestResolveTree(t *testing.T) {
+	repoDir := commitFiles(t, map[string]string{"web/Procfile": "web: ./server", "README.md": "readme"})
+
+	root, err := ResolveTree(repoDir, "HEAD", "")
+	assert.Nil(t, err)
+	web, err := ResolveTree(repoDir, "HEAD", "web")
+	assert.Nil(t, err)
+	assert.NotEqual(t, web, root, "tree of a subdirectory")
+
+	procfile, err := fs.ReadFile(NewTree(repoDir, web), "Procfile")
+	assert.Nil(t, err)
+	assert.Equal(t, string(procfile), "web: ./server", "Procfile of the subdirectory tree")
+
+	_, err = ResolveTree(repoDir, "HEAD", "README.md")
+	assert.NotNil(t, err, "tree of a file")
+	_, err = ResolveTree(repoDir, "HEAD", "missing")
+	assert.NotNil(t, err, "tree of a missing directory")
+}
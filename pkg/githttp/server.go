@@ -0,0 +1,284 @@
+// Package githttp serves the git smart HTTP protocol, so that clients which can't reach the
+// builder over SSH can still push. Pushes are authenticated with Drycc API tokens and go
+// through the same pre-receive hook, and thus the same build pipeline, as SSH pushes.
+//
+// The same server accepts uploads of gzipped source tarballs, for apps deployed without git.
+//
+// See https://git-scm.com/docs/http-protocol
+package githttp
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drycc/builder/pkg/audit"
+	"github.com/drycc/builder/pkg/controller"
+	"github.com/drycc/builder/pkg/git"
+	"github.com/drycc/builder/pkg/sshd"
+	"github.com/drycc/pkg/log"
+)
+
+const (
+	receivePack  = "git-receive-pack"
+	multiplePush = "Another git push is ongoing"
+
+	// readHeaderTimeout and idleTimeout close the connections of clients which are slow to send
+	// their request headers, or idle between requests. Request bodies and responses have no
+	// timeout, since pushes stream the output of builds until they're over.
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = 2 * time.Minute
+)
+
+var (
+	errUnauthorized = errors.New("invalid username or token")
+	errForbidden    = errors.New("user has no permission to build the app")
+
+	repoNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// Authenticator checks the credentials of a push.
+type Authenticator interface {
+	// Authenticate returns nil if token is a valid Drycc API token of username, and username
+	// may push to app.
+	Authenticate(username, token, app string) error
+}
+
+// controllerAuthenticator is the Authenticator checking tokens against the Drycc controller.
+type controllerAuthenticator struct {
+	controllerURL string
+}
+
+// Authenticate is the Authenticator interface implementation.
+func (a controllerAuthenticator) Authenticate(username, token, app string) error {
+	client, err := controller.NewForToken(a.controllerURL, token)
+	if err != nil {
+		return err
+	}
+	owner, err := controller.Whoami(client)
+	if err != nil {
+		log.Info("Failed to authenticate user %s token with the controller: %s", username, err)
+		return errUnauthorized
+	}
+	if owner != username {
+		return errUnauthorized
+	}
+	builderClient, err := controller.New(a.controllerURL)
+	if err != nil {
+		return err
+	}
+	if err := controller.CanPushApp(builderClient, username, app); err != nil {
+		log.Info("User %s can't push to app %s: %s", username, app, err)
+		return errForbidden
+	}
+	return nil
+}
+
+// receiveFunc receives a push of repo, reading the request from r and writing the result to w.
+type receiveFunc func(repo string, r io.Reader, w io.Writer, username, conndata string) error
+
+// handler serves git pushes and tarball uploads over HTTP.
+type handler struct {
+	auth           Authenticator
+	pushLock       sshd.RepositoryLock
+	auditor        *audit.Auditor
+	receive        receiveFunc
+	build          buildFunc
+	maxTarballSize int64
+}
+
+// Serve starts the git smart HTTP server on the configured port and blocks. It only returns if
+// the server fails, with the indicative error. The server uses TLS if a certificate is configured.
+func Serve(cnf *sshd.Config, gitHome string, pushLock sshd.RepositoryLock, auditor *audit.Auditor) error {
+	h := &handler{
+		auth:     controllerAuthenticator{controllerURL: cnf.ControllerURL},
+		pushLock: pushLock,
+		auditor:  auditor,
+		receive: func(repo string, r io.Reader, w io.Writer, username, conndata string) error {
+			return git.ReceiveStateless(repo, gitHome, r, w, "", username, conndata)
+		},
+		build:          buildTarball(gitHome),
+		maxTarballSize: cnf.TarballMaxSize(),
+	}
+	srv := &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", cnf.SSHHostIP, cnf.GitHTTPPort),
+		Handler:           h,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	if cnf.GitHTTPTLSCertFile != "" {
+		return srv.ListenAndServeTLS(cnf.GitHTTPTLSCertFile, cnf.GitHTTPTLSKeyFile)
+	}
+	return srv.ListenAndServe()
+}
+
+// ServeHTTP is the http.Handler interface implementation. It routes
+//
+//	GET  /<app>.git/info/refs?service=git-receive-pack
+//	POST /<app>.git/git-receive-pack
+//	POST /<app>/tarball
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	repoName, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	repoName = strings.TrimSuffix(repoName, ".git")
+	if !ok || !repoNameRegexp.MatchString(repoName) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case action == "info/refs" && r.Method == http.MethodGet:
+		if r.URL.Query().Get("service") != receivePack {
+			http.Error(w, "only git push is supported", http.StatusForbidden)
+			return
+		}
+		if _, ok := h.authenticate(w, r, repoName); !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-git-receive-pack-advertisement")
+		w.Header().Set("Cache-Control", "no-cache")
+		if err := git.AdvertiseRefs(w); err != nil {
+			log.Err("Failed to advertise refs for %s: %s", repoName, err)
+		}
+	case action == receivePack && r.Method == http.MethodPost:
+		if r.Header.Get("Content-Type") != "application/x-git-receive-pack-request" {
+			http.Error(w, "unexpected content type", http.StatusBadRequest)
+			return
+		}
+		username, ok := h.authenticate(w, r, repoName)
+		if !ok {
+			return
+		}
+		h.receivePack(w, r, repoName, username)
+	case action == "tarball" && r.Method == http.MethodPost:
+		username, ok := h.authenticate(w, r, repoName)
+		if !ok {
+			return
+		}
+		h.receiveTarball(w, r, repoName, username)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authenticate checks the basic auth credentials of r for a push to repoName. It writes the
+// error response and returns false if they are missing or invalid.
+func (h *handler) authenticate(w http.ResponseWriter, r *http.Request, repoName string) (string, bool) {
+	username, token, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Drycc Builder"`)
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return "", false
+	}
+	evt := audit.Event{
+		User:       username,
+		RemoteAddr: remoteHost(r.RemoteAddr),
+		App:        repoName,
+	}
+	if err := h.auth.Authenticate(username, token, repoName); err != nil {
+		evt.Type = audit.AuthDenied
+		evt.Error = err.Error()
+		h.auditor.Emit(evt)
+		if err == errForbidden {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return "", false
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="Drycc Builder"`)
+		http.Error(w, errUnauthorized.Error(), http.StatusUnauthorized)
+		return "", false
+	}
+	// git authenticates both the ref advertisement and the push, only record the latter
+	if r.Method == http.MethodPost {
+		evt.Type = audit.AuthAccepted
+		h.auditor.Emit(evt)
+	}
+	return username, true
+}
+
+func (h *handler) receivePack(w http.ResponseWriter, r *http.Request, repoName, username string) {
+	body := r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	h.auditor.Emit(audit.Event{
+		Type:       audit.PushStarted,
+		User:       username,
+		RemoteAddr: remoteHost(r.RemoteAddr),
+		App:        repoName,
+	})
+
+	out := &responseWriter{w: w}
+	defer out.close()
+	err := sshd.WrapInLock(h.pushLock, repoName, func() error {
+		w.Header().Set("Content-Type", "application/x-git-receive-pack-result")
+		w.Header().Set("Cache-Control", "no-cache")
+		return h.receive(repoName+".git", body, out, username, connData(r))
+	})
+	if err == sshd.ErrAlreadyLocked {
+		log.Info(multiplePush)
+		http.Error(w, multiplePush, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Err("Failed git receive: %v", err)
+	}
+}
+
+// responseWriter streams the output of git to the client as it comes, and stops writing to it
+// once the request is over, should git outlive the push lock.
+type responseWriter struct {
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	closed bool
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := rw.w.Write(p)
+	if f, ok := rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+func (rw *responseWriter) close() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.closed = true
+}
+
+// connData generates the SSH_CONNECTION environment variable the pre-receive hook expects.
+func connData(r *http.Request) string {
+	rhost, rport, _ := net.SplitHostPort(r.RemoteAddr)
+	lhost, lport := "", ""
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		lhost, lport, _ = net.SplitHostPort(addr.String())
+	}
+	return fmt.Sprintf("%s %s %s %s", rhost, rport, lhost, lport)
+}
+
+// remoteHost returns the host part of addr, or addr itself if it has no port.
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
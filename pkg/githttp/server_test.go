@@ -0,0 +1,161 @@
+package githttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drycc/builder/pkg/sshd"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAuthenticator struct{}
+
+func (fakeAuthenticator) Authenticate(username, token, app string) error {
+	if username != "drycc" || token != "secret" {
+		return errUnauthorized
+	}
+	if app != "demo" {
+		return errForbidden
+	}
+	return nil
+}
+
+type receiveCall struct {
+	repo     string
+	body     string
+	username string
+}
+
+func newTestHandler(pushLock sshd.RepositoryLock) (*handler, *[]receiveCall) {
+	calls := &[]receiveCall{}
+	return &handler{
+		auth:     fakeAuthenticator{},
+		pushLock: pushLock,
+		receive: func(repo string, r io.Reader, w io.Writer, username, _ string) error {
+			body, _ := io.ReadAll(r)
+			*calls = append(*calls, receiveCall{repo: repo, body: string(body), username: username})
+			_, err := w.Write([]byte("OK"))
+			return err
+		},
+	}, calls
+}
+
+func newReceivePackRequest(path, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-git-receive-pack-request")
+	return req
+}
+
+func TestAuthentication(t *testing.T) {
+	h, calls := newTestHandler(sshd.NewInMemoryRepositoryLock(time.Minute))
+
+	req := newReceivePackRequest("/demo.git/git-receive-pack", "")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, http.StatusUnauthorized, "response code without credentials")
+	assert.NotEqual(t, w.Header().Get("WWW-Authenticate"), "", "authentication challenge")
+
+	req = newReceivePackRequest("/demo.git/git-receive-pack", "")
+	req.SetBasicAuth("drycc", "wrong")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, http.StatusUnauthorized, "response code with an invalid token")
+
+	req = newReceivePackRequest("/other.git/git-receive-pack", "")
+	req.SetBasicAuth("drycc", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, http.StatusForbidden, "response code for an app without access")
+
+	assert.Equal(t, len(*calls), 0, "no push must be received")
+}
+
+func TestRouting(t *testing.T) {
+	h, _ := newTestHandler(sshd.NewInMemoryRepositoryLock(time.Minute))
+	cases := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, "/", http.StatusNotFound},
+		{http.MethodGet, "/demo.git", http.StatusNotFound},
+		{http.MethodGet, "/../demo.git/info/refs?service=git-receive-pack", http.StatusNotFound},
+		{http.MethodGet, "/demo.git/HEAD", http.StatusNotFound},
+		{http.MethodGet, "/demo.git/info/refs", http.StatusForbidden},
+		{http.MethodGet, "/demo.git/info/refs?service=git-upload-pack", http.StatusForbidden},
+		{http.MethodGet, "/demo.git/git-receive-pack", http.StatusNotFound},
+	}
+	for _, caze := range cases {
+		req := httptest.NewRequest(caze.method, caze.path, nil)
+		req.SetBasicAuth("drycc", "secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, w.Code, caze.code, "response code for %s %s", caze.method, caze.path)
+	}
+}
+
+func TestInfoRefs(t *testing.T) {
+	h, _ := newTestHandler(sshd.NewInMemoryRepositoryLock(time.Minute))
+	req := httptest.NewRequest(http.MethodGet, "/demo.git/info/refs?service=git-receive-pack", nil)
+	req.SetBasicAuth("drycc", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, http.StatusOK, "response code")
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/x-git-receive-pack-advertisement", "content type")
+	assert.True(t, strings.HasPrefix(w.Body.String(), "001f# service=git-receive-pack\n0000"), "advertisement %q", w.Body.String())
+}
+
+func TestReceivePack(t *testing.T) {
+	h, calls := newTestHandler(sshd.NewInMemoryRepositoryLock(time.Minute))
+
+	req := newReceivePackRequest("/demo/git-receive-pack", "pack")
+	req.SetBasicAuth("drycc", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, http.StatusOK, "response code")
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/x-git-receive-pack-result", "content type")
+	assert.Equal(t, w.Body.String(), "OK", "response body")
+
+	gzipped := new(bytes.Buffer)
+	gz := gzip.NewWriter(gzipped)
+	gz.Write([]byte("gzipped pack"))
+	gz.Close()
+	req = newReceivePackRequest("/demo.git/git-receive-pack", gzipped.String())
+	req.Header.Set("Content-Encoding", "gzip")
+	req.SetBasicAuth("drycc", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, http.StatusOK, "response code")
+
+	assert.Equal(t, *calls, []receiveCall{
+		{repo: "demo.git", body: "pack", username: "drycc"},
+		{repo: "demo.git", body: "gzipped pack", username: "drycc"},
+	})
+}
+
+func TestReceivePackLocked(t *testing.T) {
+	pushLock := sshd.NewInMemoryRepositoryLock(time.Minute)
+	h, calls := newTestHandler(pushLock)
+	assert.Nil(t, pushLock.Lock("demo"))
+	defer pushLock.Unlock("demo")
+
+	req := newReceivePackRequest("/demo.git/git-receive-pack", "pack")
+	req.SetBasicAuth("drycc", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, http.StatusConflict, "response code")
+	assert.Equal(t, len(*calls), 0, "no push must be received")
+}
+
+func TestConnData(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:51234"
+	assert.Equal(t, connData(req), "10.0.0.1 51234  ", "connection data")
+	assert.Equal(t, remoteHost(req.RemoteAddr), "10.0.0.1", "remote host")
+}
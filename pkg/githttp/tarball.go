@@ -0,0 +1,169 @@
+package githttp
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+
+	"github.com/drycc/builder/pkg/audit"
+	"github.com/drycc/builder/pkg/sshd"
+	"github.com/drycc/pkg/log"
+)
+
+// buildStatusTrailer is the HTTP trailer telling the client of a tarball upload whether the
+// build succeeded, since the response status is sent before the build output.
+const buildStatusTrailer = "X-Drycc-Build-Status"
+
+// buildFunc builds and releases app from the tarball at path, writing the build output to w.
+type buildFunc func(app, path string, w io.Writer, username, conndata string) error
+
+// buildTarball returns the buildFunc running the tarball-receive command. Like the git
+// pre-receive hook, it runs in its own process so that its output goes to the client only.
+func buildTarball(gitHome string) buildFunc {
+	return func(app, path string, w io.Writer, username, conndata string) error {
+		cmd := exec.Command("boot", "tarball-receive", path)
+		cmd.Env = append(os.Environ(),
+			fmt.Sprintf("GIT_HOME=%s", gitHome),
+			fmt.Sprintf("SSH_CONNECTION=%s", conndata),
+			fmt.Sprintf("SSH_ORIGINAL_COMMAND=tarball-receive '%s'", app),
+			fmt.Sprintf("REPOSITORY=%s", app),
+			fmt.Sprintf("USERNAME=%s", username),
+			"FINGERPRINT=",
+		)
+		cmd.Stdout = w
+		cmd.Stderr = w
+		return cmd.Run()
+	}
+}
+
+// receiveTarball saves the gzipped tarball in the body of r and builds app from it. The response
+// goes through out only, since the build may outlive the push lock, and thus the request.
+func (h *handler) receiveTarball(w http.ResponseWriter, r *http.Request, app, username string) {
+	out := &tarballResponse{responseWriter: responseWriter{w: w}}
+	defer out.close()
+	err := sshd.WrapInLock(h.pushLock, app, func() error {
+		f, err := os.CreateTemp("", app+"-*.tar.gz")
+		if err != nil {
+			out.error("unable to store the tarball", http.StatusInternalServerError)
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		digest, err := saveTarball(f, http.MaxBytesReader(w, r.Body, h.maxTarballSize))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				out.error(fmt.Sprintf("tarball exceeds %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+			} else {
+				out.error(err.Error(), http.StatusBadRequest)
+			}
+			return err
+		}
+
+		h.auditor.Emit(audit.Event{
+			Type:       audit.PushStarted,
+			User:       username,
+			RemoteAddr: remoteHost(r.RemoteAddr),
+			App:        app,
+			NewRev:     digest,
+		})
+
+		out.writeHeader()
+		err = h.build(app, f.Name(), out, username, connData(r))
+		out.finish(err, "")
+		return err
+	})
+	if err == sshd.ErrAlreadyLocked {
+		log.Info(multiplePush)
+		out.error(multiplePush, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Err("Failed tarball receive: %v", err)
+		// e.g. the push lock timed out, which the build doesn't tell
+		out.finish(err, fmt.Sprintf("\nerror: %s\n", err))
+	}
+}
+
+// tarballResponse is the responseWriter of tarball uploads, whose build status goes in the
+// buildStatusTrailer once the build output is over.
+type tarballResponse struct {
+	responseWriter
+	// started is whether the header is written, and finished whether the build status is.
+	started  bool
+	finished bool
+}
+
+// writeHeader writes the header of the build output, declaring the build status trailer.
+func (t *tarballResponse) writeHeader() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || t.started {
+		return
+	}
+	header := t.w.Header()
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Trailer", buildStatusTrailer)
+	t.w.WriteHeader(http.StatusOK)
+	t.started = true
+}
+
+// error replies with message and code, unless the build output started.
+func (t *tarballResponse) error(message string, code int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || t.started {
+		return
+	}
+	http.Error(t.w, message, code)
+	t.started, t.finished = true, true
+}
+
+// finish writes message after the build output, and the build status, failed unless err is nil.
+// Builds are only finished once: a push lock timing out while the build runs fails it, and the
+// build finishing afterwards doesn't change it.
+func (t *tarballResponse) finish(err error, message string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || t.finished {
+		return
+	}
+	// the build output stops here, even if the build still runs
+	t.finished, t.closed = true, true
+	if !t.started {
+		http.Error(t.w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	io.WriteString(t.w, message)
+	status := "succeeded"
+	if err != nil {
+		status = "failed"
+	}
+	t.w.Header().Set(buildStatusTrailer, status)
+}
+
+// saveTarball copies the gzipped tarball read from r to f, and returns its hex encoded SHA-256
+// digest.
+func saveTarball(f *os.File, r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", fmt.Errorf("the upload is not a gzipped tarball (%s)", err)
+	}
+	gz.Close()
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
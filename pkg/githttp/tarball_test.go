@@ -0,0 +1,128 @@
+package githttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/drycc/builder/pkg/sshd"
+	"github.com/stretchr/testify/assert"
+)
+
+type buildCall struct {
+	app      string
+	tarball  string
+	username string
+}
+
+func newTarballTestHandler(pushLock sshd.RepositoryLock, buildErr error) (*handler, *[]buildCall) {
+	h, _ := newTestHandler(pushLock)
+	calls := &[]buildCall{}
+	h.maxTarballSize = 1 << 20
+	h.build = func(app, path string, w io.Writer, username, _ string) error {
+		tarball, _ := os.ReadFile(path)
+		*calls = append(*calls, buildCall{app: app, tarball: string(tarball), username: username})
+		_, err := w.Write([]byte("Build complete."))
+		if err != nil {
+			return err
+		}
+		return buildErr
+	}
+	return h, calls
+}
+
+func gzipped(s string) string {
+	b := new(bytes.Buffer)
+	gz := gzip.NewWriter(b)
+	gz.Write([]byte(s))
+	gz.Close()
+	return b.String()
+}
+
+func newTarballRequest(path, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/gzip")
+	req.SetBasicAuth("drycc", "secret")
+	return req
+}
+
+func TestReceiveTarball(t *testing.T) {
+	h, calls := newTarballTestHandler(sshd.NewInMemoryRepositoryLock(time.Minute), nil)
+	tarball := gzipped("tarball")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newTarballRequest("/demo/tarball", tarball))
+	assert.Equal(t, w.Code, http.StatusOK, "response code")
+	assert.Equal(t, w.Body.String(), "Build complete.", "response body")
+	assert.Equal(t, w.Result().Trailer.Get(buildStatusTrailer), "succeeded", "build status")
+	assert.Equal(t, *calls, []buildCall{{app: "demo", tarball: tarball, username: "drycc"}})
+
+	h, _ = newTarballTestHandler(sshd.NewInMemoryRepositoryLock(time.Minute), errors.New("build failed"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, newTarballRequest("/demo/tarball", tarball))
+	assert.Equal(t, w.Code, http.StatusOK, "response code")
+	assert.Equal(t, w.Result().Trailer.Get(buildStatusTrailer), "failed", "build status")
+}
+
+func TestReceiveTarballLockTimeout(t *testing.T) {
+	h, _ := newTarballTestHandler(sshd.NewInMemoryRepositoryLock(10*time.Millisecond), nil)
+	done := make(chan struct{})
+	defer close(done)
+	h.build = func(app, path string, w io.Writer, username, _ string) error {
+		w.Write([]byte("Building..."))
+		<-done
+		w.Write([]byte("Build complete."))
+		return nil
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newTarballRequest("/demo/tarball", gzipped("tarball")))
+	assert.Equal(t, http.StatusOK, w.Code, "response code")
+	assert.Equal(t, "Building...\nerror: demo lock exceeded timeout\n", w.Body.String(), "response body")
+	assert.Equal(t, "failed", w.Result().Trailer.Get(buildStatusTrailer), "build status")
+}
+
+func TestReceiveTarballRejected(t *testing.T) {
+	pushLock := sshd.NewInMemoryRepositoryLock(time.Minute)
+	h, calls := newTarballTestHandler(pushLock, nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newTarballRequest("/other/tarball", gzipped("tarball")))
+	assert.Equal(t, w.Code, http.StatusForbidden, "response code for an app without access")
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, newTarballRequest("/demo/tarball", "not gzipped"))
+	assert.Equal(t, w.Code, http.StatusBadRequest, "response code for a plain upload")
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, newTarballRequest("/demo/tarball", string(make([]byte, h.maxTarballSize+1))))
+	assert.Equal(t, w.Code, http.StatusRequestEntityTooLarge, "response code for a large upload")
+
+	assert.Nil(t, pushLock.Lock("demo"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, newTarballRequest("/demo/tarball", gzipped("tarball")))
+	assert.Equal(t, w.Code, http.StatusConflict, "response code during a push")
+	pushLock.Unlock("demo")
+
+	assert.Equal(t, len(*calls), 0, "no tarball must be built")
+}
+
+func TestSaveTarball(t *testing.T) {
+	f, err := os.CreateTemp("", "tarball")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	digest, err := saveTarball(f, bytes.NewBufferString(gzipped("tarball")))
+	assert.Nil(t, err)
+	assert.Equal(t, len(digest), 64, "hex encoded sha256 digest length")
+	saved, err := os.ReadFile(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, string(saved), gzipped("tarball"), "saved tarball")
+}
@@ -5,8 +5,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,19 +16,21 @@ import (
 	"strings"
 
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/drycc/builder/pkg/audit"
 	"github.com/drycc/builder/pkg/controller"
+	"github.com/drycc/builder/pkg/forge"
 	"github.com/drycc/builder/pkg/git"
 	"github.com/drycc/builder/pkg/k8s"
+	"github.com/drycc/builder/pkg/notify"
 	"github.com/drycc/builder/pkg/sys"
 	drycc "github.com/drycc/controller-sdk-go"
 	dryccAPI "github.com/drycc/controller-sdk-go/api"
 	"github.com/drycc/controller-sdk-go/hooks"
 	"github.com/drycc/pkg/log"
 	"gopkg.in/yaml.v3"
-	corev1 "k8s.io/api/core/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
 )
 
 const (
@@ -34,6 +38,11 @@ const (
 	TarKeyPattern = "%s/tar"
 	// GitKeyPattern is the template for storing git key files.
 	GitKeyPattern = "home/%s:git-%s"
+	// TarballKeyPattern is the template for storing the files of uploaded tarballs.
+	TarballKeyPattern = "home/%s:tar-%s"
+	// SourceTreeKeyPattern is the template for storing the id of the git tree last built from a
+	// subdirectory of a repo.
+	SourceTreeKeyPattern = "home/%s:tree"
 )
 
 // repoCmd returns exec.Command(first, others...) with its current working directory repoDir
@@ -61,35 +70,96 @@ func build(
 	// kubeClient *client.Client,
 	kubeClient *kubernetes.Clientset,
 	env sys.Env,
+	auditor *audit.Auditor,
+	notifier *notify.Dispatcher,
 	rawGitSha string,
 ) error {
-	// Rewrite regular expression, compatible with slug type
-	storagedriver.PathRegexp = regexp.MustCompile(`^([A-Za-z0-9._:-]*(/[A-Za-z0-9._:-]+)*)+$`)
-
 	repo := conf.Repository
 	gitSha, err := git.NewSha(rawGitSha)
 	if err != nil {
 		return err
 	}
 
-	appName := conf.App()
-
 	repoDir := filepath.Join(conf.GitHome, repo)
-	buildDir := filepath.Join(repoDir, "build")
 
-	if err := os.MkdirAll(buildDir, 0o700); err != nil {
-		return fmt.Errorf("making the build directory %s (%s)", buildDir, err)
+	src := gitSource(repoDir, gitSha, "")
+	src.subtree = func(dir string) (source, string, error) {
+		tree, err := git.ResolveTree(repoDir, gitSha.Full(), dir)
+		if err != nil {
+			return source{}, "", err
+		}
+		return gitSource(repoDir, gitSha, dir), tree, nil
 	}
+	return buildSource(conf, storageDriver, kubeClient, env, auditor, notifier, src)
+}
 
-	tmpDir, err := os.MkdirTemp(buildDir, "tmp")
-	if err != nil {
-		return fmt.Errorf("unable to create tmpdir %s (%s)", buildDir, err)
+// gitSource returns the source of the commit sha of the repo at repoDir, made of the files of its
+// dir directory, "" for all of them.
+func gitSource(repoDir string, sha *git.SHA, dir string) source {
+	// <sha>:<dir> names the tree of dir, the root tree for an empty dir
+	tree := fmt.Sprintf("%s:%s", sha.Full(), dir)
+	src := source{
+		kind:         "git",
+		version:      sha.Full(),
+		shortVersion: sha.Short(),
+		archive: func(w io.Writer) error {
+			gitArchiveCmd := repoCmd(repoDir, "git", "archive", "--format=tar.gz", tree)
+			gitArchiveCmd.Stdout = w
+			gitArchiveCmd.Stderr = os.Stderr
+			if err := run(gitArchiveCmd); err != nil {
+				return fmt.Errorf("running %s (%s)", strings.Join(gitArchiveCmd.Args, " "), err)
+			}
+			return nil
+		},
+		files: git.NewTree(repoDir, tree),
 	}
-	defer func() {
-		if err := os.RemoveAll(tmpDir); err != nil {
-			log.Info("unable to remove tmpdir %s (%s)", tmpDir, err)
+	src.checkout = func(opts git.CheckoutOptions) (source, func(), error) {
+		workDir, err := git.Checkout(repoDir, sha.Full(), opts, os.Stdout)
+		if err != nil {
+			return source{}, nil, err
 		}
-	}()
+		root := filepath.Join(workDir, dir)
+		checkedOut := src
+		checkedOut.archive = func(w io.Writer) error {
+			// git archive honors export-ignore attributes, do the same for checkouts
+			ignored, err := git.ExportIgnored(workDir, dir)
+			if err != nil {
+				return err
+			}
+			return archiveDir(w, root, func(name string, _ bool) bool { return ignored[name] })
+		}
+		// unlike os.DirFS, the FS of a root doesn't follow symlinks out of it, e.g. to the
+		// credentials of the builder, like the tree of the commit doesn't
+		files, err := os.OpenRoot(root)
+		if err != nil {
+			os.RemoveAll(workDir)
+			return source{}, nil, fmt.Errorf("opening the checkout of %s (%s)", sha.Short(), err)
+		}
+		checkedOut.files = files.FS()
+		checkedOut.checkout = nil
+		return checkedOut, func() {
+			files.Close()
+			os.RemoveAll(workDir)
+		}, nil
+	}
+	return src
+}
+
+// buildSource uploads src to the object storage, builds it into an image with an imagebuild job
+// and releases that image through the controller.
+func buildSource(
+	conf *Config,
+	storageDriver storagedriver.StorageDriver,
+	kubeClient *kubernetes.Clientset,
+	env sys.Env,
+	auditor *audit.Auditor,
+	notifier *notify.Dispatcher,
+	src source,
+) (err error) {
+	// Rewrite regular expression, compatible with slug type
+	storagedriver.PathRegexp = regexp.MustCompile(`^([A-Za-z0-9._:-]*(/[A-Za-z0-9._:-]+)*)+$`)
+
+	appName := conf.App()
 
 	client, err := controller.New(conf.ControllerURL)
 	if err != nil {
@@ -101,174 +171,272 @@ func build(
 	if controller.CheckAPICompat(client, err) != nil {
 		return err
 	}
-
-	// build a tarball from the new objects
-	appTgz := fmt.Sprintf("%s.tar.gz", appName)
-	gitArchiveCmd := repoCmd(repoDir, "git", "archive", "--format=tar.gz", fmt.Sprintf("--output=%s", appTgz), gitSha.Short())
-	gitArchiveCmd.Stdout = os.Stdout
-	gitArchiveCmd.Stderr = os.Stderr
-	if err := run(gitArchiveCmd); err != nil {
-		return fmt.Errorf("running %s (%s)", strings.Join(gitArchiveCmd.Args, " "), err)
+	values := globalConfigValues(appConf.Values)
+	if src.kind == "git" {
+		notifier = withForgeStatus(notifier, conf, values)
 	}
-	absAppTgz := fmt.Sprintf("%s/%s", repoDir, appTgz)
+	notification := notify.Notification{
+		App:  appName,
+		Sha:  src.version,
+		User: conf.Username,
+	}
+	// failures before the build is queued are reported too, so that forges show a status for
+	// every push
+	defer func() {
+		if err != nil {
+			notification.Stage = notify.Failed
+			notification.Error = err.Error()
+			notifier.Dispatch(notification)
+		}
+	}()
 
-	// untar the archive into the temp dir
-	tarCmd := repoCmd(repoDir, "tar", "-xzf", appTgz, "-C", fmt.Sprintf("%s/", tmpDir))
-	tarCmd.Stdout = os.Stdout
-	tarCmd.Stderr = os.Stderr
-	if err := run(tarCmd); err != nil {
-		return fmt.Errorf("running %s (%s)", strings.Join(tarCmd.Args, " "), err)
+	// the id of the tree built from a subdirectory, to skip the next build if it's unchanged
+	treeKey, treeID := fmt.Sprintf(SourceTreeKeyPattern, appName), ""
+	sourceDir, err := cleanSourceDir(values[sourceDirKey])
+	if err != nil {
+		return fmt.Errorf("invalid %s (%s)", sourceDirKey, err)
+	}
+	if sourceDir != "" && src.subtree == nil {
+		log.Info("Ignoring %s, only git pushes can be built from a subdirectory.", sourceDirKey)
+	} else if sourceDir != "" {
+		if src, treeID, err = src.subtree(sourceDir); err != nil {
+			return fmt.Errorf("building from %s (%s)", sourceDir, err)
+		}
+		// every push is received into a new repo, so the pushed range always starts from scratch
+		// and changes can only be told from the tree of the last build
+		lastTreeID, err := storageDriver.GetContent(context.Background(), treeKey)
+		if err == nil && string(lastTreeID) == treeID {
+			log.Info("No changes under %s since the last build, skipping it.", sourceDir)
+			return nil
+		}
+		log.Info("Building from %s", sourceDir)
 	}
 
-	stack := getStack(tmpDir, appConf)
+	checkoutOpts := git.CheckoutOptions{
+		Submodules:      values[submodulesKey] == "true",
+		LFSURL:          values[lfsURLKey],
+		CredentialsFile: conf.GitCredentialsFile,
+	}
+	if (checkoutOpts.Submodules || checkoutOpts.LFSURL != "") && src.checkout == nil {
+		log.Info("Ignoring %s and %s, only git pushes have submodules and LFS objects.", submodulesKey, lfsURLKey)
+	} else if checkoutOpts.Submodules || checkoutOpts.LFSURL != "" {
+		log.Info("Checking out submodules and LFS objects...")
+		var removeCheckout func()
+		if src, removeCheckout, err = src.checkout(checkoutOpts); err != nil {
+			return fmt.Errorf("checking out the source (%s)", err)
+		}
+		defer removeCheckout()
+	}
 
-	appTgzdata, err := os.ReadFile(absAppTgz)
+	rules, err := readIgnoreRules(src.files)
 	if err != nil {
-		return fmt.Errorf("error while reading file %s: (%s)", appTgz, err)
+		return err
 	}
-
-	tarKey := fmt.Sprintf(TarKeyPattern, fmt.Sprintf(GitKeyPattern, appName, gitSha.Short()))
+	tarKey := src.key(appName)
 	log.Debug("Uploading tar to %s", tarKey)
+	tarSize, ignored, err := uploadSource(storageDriver, tarKey, src, rules)
+	if err != nil {
+		return fmt.Errorf("uploading the source to %s (%s)", tarKey, err)
+	}
+	if rules != nil {
+		log.Info("Uploaded the source, %s, leaving out %d paths matching %s", formatSize(tarSize), ignored, ignoreFile)
+	} else {
+		log.Info("Uploaded the source, %s", formatSize(tarSize))
+	}
 
-	if err := storageDriver.PutContent(context.Background(), tarKey, appTgzdata); err != nil {
-		return fmt.Errorf("uploading %s to %s (%v)", absAppTgz, tarKey, err)
+	stack, reason, err := getStack(src.files, appConf)
+	if err != nil {
+		return fmt.Errorf("choosing the stack (%s)", err)
 	}
+	log.Info("Using the %s stack (%s)", stack.Name, reason)
 
 	builderPodNodeSelector, err := buildBuilderPodNodeSelector(conf.BuilderPodNodeSelector)
 	if err != nil {
 		return fmt.Errorf("error build builder pod node selector %s", err)
 	}
+	for key, value := range stack.NodeSelector {
+		builderPodNodeSelector[key] = value
+	}
 	builderName := "drycc-imagebuilder"
-	imagePullPolicy, err := k8s.PullPolicyFromString(conf.ImagebuilderImagePullPolicy)
+	pullPolicy := conf.ImagebuilderImagePullPolicy
+	if stack.ImagePullPolicy != "" {
+		pullPolicy = stack.ImagePullPolicy
+	}
+	imagePullPolicy, err := k8s.PullPolicyFromString(pullPolicy)
+	if err != nil {
+		return err
+	}
+	resources, err := builderPodResources(conf, stack, values)
+	if err != nil {
+		return err
+	}
+	scheduling, err := builderPodScheduling(conf, stack)
+	if err != nil {
+		return err
+	}
+	security, err := builderPodSecurity(conf, stack)
+	if err != nil {
+		return err
+	}
+	identity, err := builderPodIdentity(conf, stack, values)
+	if err != nil {
+		return err
+	}
+	cache, err := builderPodCache(conf, appName, stack, values)
+	if err != nil {
+		return err
+	}
+	deadline, err := builderPodDeadline(conf, stack, values)
+	if err != nil {
+		return err
+	}
+	retries, err := builderPodRetryPolicy(conf)
 	if err != nil {
 		return err
 	}
-	securityContext := k8s.SecurityContextFromPrivileged(true)
+	pvcs := kubeClient.CoreV1().PersistentVolumeClaims(conf.PodNamespace)
+	if err := cache.ensureClaim(pvcs, conf.BuildCacheSize, conf.BuildCacheStorageClass); err != nil {
+		return fmt.Errorf("creating the build cache (%s)", err)
+	}
 
-	imageName := fmt.Sprintf("%s:git-%s", appName, gitSha.Short())
-	buildJobName := imagebuilderJobName(appName, gitSha.Short())
+	imageName := src.imageName(appName)
+	buildJobName := imagebuilderJobName(appName, src.shortVersion)
 
 	builderImageEnv, err := getImagebuilderEnv(&imageName, conf, env)
 	if err != nil {
 		return fmt.Errorf("error getting private registry details %s", err)
 	}
-	builderImageEnv["DRYCC_STACK"] = stack["name"]
+	builderImageEnv["DRYCC_STACK"] = stack.Name
+	builderImageEnv[securityProfileKey] = security.Profile
+
+	notification.Image = imageName
+	notification.Job = buildJobName
+	notification.Stack = stack.Name
+	notification.Stage = notify.Queued
+	notifier.Dispatch(notification)
+
+	// the config of the app goes to the build in a secret, deleted once the job is over. The pods
+	// of the builds the job retries need it, so it's deleted with the job, which owns it, if the
+	// builder stops watching the job before, e.g. on timeouts.
+	secretsClient := kubeClient.CoreV1().Secrets(conf.PodNamespace)
+	jobsInterface := kubeClient.BatchV1().Jobs(conf.PodNamespace)
+	envSecret := ""
+	var newJob *batchv1.Job
+	owned := false
+	if appEnv := buildEnv(appConf.Values, conf.BuildEnvGlobal); len(appEnv) > 0 {
+		envSecret = buildJobName
+		if err := createAppEnvConfigSecret(secretsClient, envSecret, appEnv); err != nil {
+			return fmt.Errorf("creating the build config secret (%s)", err)
+		}
+		defer func() {
+			if owned && !waitForJobEnd(jobsInterface, newJob.Name, jobEndWait) {
+				log.Debug("Leaving the build config secret %s to the job %s, which isn't over", envSecret, newJob.Name)
+				return
+			}
+			if err := deleteSecret(secretsClient, envSecret); err != nil {
+				log.Err("Error deleting the build config secret %s (%s)", envSecret, err)
+			}
+		}()
+	}
 
 	job := createBuilderJob(
 		conf.Debug,
 		buildJobName,
 		conf.PodNamespace,
-		appConf.Values,
+		envSecret,
 		tarKey,
-		gitSha.Short(),
+		src.shortVersion,
 		imageName,
 		builderName,
-		stack["image"],
+		stack,
 		builderImageEnv,
 		imagePullPolicy,
-		securityContext,
+		security,
 		builderPodNodeSelector,
+		resources,
+		scheduling,
+		identity,
 	)
+	cache.apply(job)
+	retries.apply(job)
+	// the job ends even if the builder dies before
+	if deadline > 0 {
+		job.Spec.ActiveDeadlineSeconds = &deadline
+	}
 
 	log.Info("Starting build... but first, coffee!")
-	log.Debug("Use image %s: %s", stack["name"], stack["image"])
+	log.Debug("Use image %s: %s", stack.Name, stack.Image)
 	log.Debug("Starting job %s", buildJobName)
-	json, err := prettyPrintJSON(job)
+	json, err := prettyPrintJSON(redactJob(job))
 	if err == nil {
 		log.Debug("Job spec: %v", json)
 	} else {
 		log.Debug("Error creating json representation of Job spec: %v", err)
 	}
-	jobsInterface := kubeClient.BatchV1().Jobs(conf.PodNamespace)
 
-	newJob, err := jobsInterface.Create(context.TODO(), job, metav1.CreateOptions{})
+	newJob, err = jobsInterface.Create(context.TODO(), job, metav1.CreateOptions{})
 	if err != nil {
 		return fmt.Errorf("creating builder pod (%s)", err)
 	}
-
-	pw := k8s.NewPodWatcher(*kubeClient, conf.PodNamespace)
-	stopCh := make(chan struct{})
-	defer close(stopCh)
-	go pw.Controller.Run(stopCh)
-
-	if err := waitForPod(pw, newJob.Name, conf.SessionIdleInterval(), conf.BuilderPodTickDuration(), conf.BuilderPodWaitDuration()); err != nil {
-		return fmt.Errorf("watching events for builder pod startup (%s)", err)
-	}
-
-	options := metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("job-name=%s", newJob.Name),
-	}
-	podList, err := kubeClient.CoreV1().Pods(newJob.Namespace).List(context.Background(), options)
-	if err != nil {
-		return fmt.Errorf("list pods %s fail: (%s)", newJob.Name, err)
-	}
-
-	req := kubeClient.CoreV1().RESTClient().Get().Namespace(newJob.Namespace).Name(podList.Items[0].Name).Resource("pods").SubResource("log").VersionedParams(
-		&corev1.PodLogOptions{
-			Follow: true,
-		}, scheme.ParameterCodec)
-
-	rc, err := req.Stream(context.TODO())
-	if err != nil {
-		return fmt.Errorf("attempting to stream logs (%s)", err)
-	}
-	defer rc.Close()
-
-	size, err := io.Copy(os.Stdout, rc)
-	if err != nil {
-		return fmt.Errorf("fetching builder logs (%s)", err)
+	if envSecret != "" {
+		if err := ownSecret(secretsClient, envSecret, newJob); err != nil {
+			log.Err("Error making the job %s the owner of the secret %s (%s)", newJob.Name, envSecret, err)
+		} else {
+			owned = true
+		}
 	}
-	log.Debug("size of streamed logs %v", size)
 
-	log.Debug(
-		"Waiting for the %s/%s pod to end. Checking every %s for %s",
-		newJob.Namespace,
-		newJob.Name,
-		conf.BuilderPodTickDuration(),
-		conf.BuilderPodWaitDuration(),
-	)
-	// check the state and exit code of the build pod.
-	// if the code is not 0 return error
-	if err := waitForPodEnd(pw, newJob.Name, conf.BuilderPodTickDuration(), conf.BuilderPodWaitDuration()); err != nil {
-		return fmt.Errorf("error getting builder pod status (%s)", err)
-	}
-	log.Debug("Done")
-	log.Debug("Checking for builder pod exit code")
-	buildPod, err := kubeClient.CoreV1().Pods(newJob.Namespace).Get(context.TODO(), podList.Items[0].Name, metav1.GetOptions{})
+	// the pods and the events of the job come from the builder server, which watches the ones of
+	// every build
+	jw, err := k8s.WatchJob(conf.BuildWatcherSocket, newJob.Name)
 	if err != nil {
-		return fmt.Errorf("error getting builder pod status (%s)", err)
-	}
-
-	for _, containerStatus := range buildPod.Status.ContainerStatuses {
-		state := containerStatus.State.Terminated
-		if state.ExitCode != 0 {
-			return fmt.Errorf("build pod exited with code %d, stopping build", state.ExitCode)
-		}
+		return fmt.Errorf("watching the build job %s (%s)", newJob.Name, err)
+	}
+	defer jw.Stop()
+	timestamps := values[logTimestampsKey] == "true"
+	if err := followBuildJob(kubeClient, conf, jw, newJob.Name, deadline, os.Stdout, timestamps, func() {
+		notification.Stage = notify.Started
+		notifier.Dispatch(notification)
+	}); err != nil {
+		return err
 	}
-	log.Debug("Done")
 
-	procfile, err := getProcfile(tmpDir)
+	procfile, err := getProcfile(src.files)
 	if err != nil {
 		return err
 	}
-	dockerfile, err := getDockerfile(tmpDir, stack)
+	dockerfile, err := getDockerfile(src.files, stack)
 	if err != nil {
 		return err
 	}
-	dryccfile, err := drycc.ParseDryccfile(filepath.Join(tmpDir, ".drycc"))
+	dryccfile, err := parseDryccfile(src.files)
 	if err != nil {
 		return err
 	}
 	log.Info("Build complete.")
+	notification.Stage = notify.Succeeded
+	notifier.Dispatch(notification)
 
 	quit := progress("...", conf.SessionIdleInterval())
 	log.Info("Launching App...")
-	release, err := hooks.CreateBuild(client, conf.Username, conf.App(), imageName, stack["name"], gitSha.Short(), procfile, dryccfile, dockerfile)
+	release, err := hooks.CreateBuild(client, conf.Username, conf.App(), imageName, stack.Name, src.shortVersion, procfile, dryccfile, dockerfile)
 	quit <- true
 	<-quit
 	if controller.CheckAPICompat(client, err) != nil {
 		return fmt.Errorf("the controller returned an error when publishing the release: %s", err)
 	}
+	auditor.Emit(audit.Event{
+		Type:        audit.ReleaseCreated,
+		User:        conf.Username,
+		Fingerprint: conf.Fingerprint,
+		App:         appName,
+		NewRev:      src.version,
+		Release:     release,
+	})
+	notification.Stage = notify.Released
+	notification.Release = release
+	notifier.Dispatch(notification)
+	recordSourceTree(storageDriver, treeKey, treeID)
 
 	log.Info("Done, %s:v%d deployed to Workflow\n", appName, release)
 	log.Info("Use 'drycc open' to view this application in your browser\n")
@@ -277,6 +445,52 @@ func build(
 	return nil
 }
 
+// recordSourceTree stores treeID, the id of the tree just built, at treeKey. An empty treeID
+// forgets the last one, as the whole source has been built.
+func recordSourceTree(storageDriver storagedriver.StorageDriver, treeKey, treeID string) {
+	var err error
+	if treeID == "" {
+		if err = storageDriver.Delete(context.Background(), treeKey); errors.As(err, &storagedriver.PathNotFoundError{}) {
+			err = nil
+		}
+	} else {
+		err = storageDriver.PutContent(context.Background(), treeKey, []byte(treeID))
+	}
+	if err != nil {
+		log.Info("unable to record the built source tree at %s (%s)", treeKey, err)
+	}
+}
+
+// globalConfigValues returns the values of the global group of an app's config.
+func globalConfigValues(values []dryccAPI.ConfigValue) map[string]string {
+	global := make(map[string]string)
+	for _, v := range values {
+		if v.Group == "global" {
+			global[v.Name] = fmt.Sprintf("%v", v.Value)
+		}
+	}
+	return global
+}
+
+// withForgeStatus adds reporting commit statuses to notifier, if the app has a forge repository
+// configured in values. Misconfigurations are reported to the user but don't stop the build.
+func withForgeStatus(notifier *notify.Dispatcher, conf *Config, values map[string]string) *notify.Dispatcher {
+	repo, err := forge.RepositoryFromValues(values, conf.Forge.AllowedURLs())
+	if err != nil {
+		log.Info("Not reporting the build status: %s", err)
+		return notifier
+	}
+	if repo == nil {
+		return notifier
+	}
+	forgeClient, err := forge.NewClient(*repo, conf.Forge.Timeout())
+	if err != nil {
+		log.Info("Not reporting the build status to %s: %s", repo.Name, err)
+		return notifier
+	}
+	return notifier.With(forge.NewStatusHook(forgeClient, conf.Forge))
+}
+
 func buildBuilderPodNodeSelector(config string) (map[string]string, error) {
 	selector := make(map[string]string)
 	if config != "" {
@@ -303,31 +517,50 @@ func prettyPrintJSON(data any) (string, error) {
 	return formatted.String(), nil
 }
 
-func getProcfile(dirName string) (dryccAPI.ProcessType, error) {
+func getProcfile(files fs.FS) (dryccAPI.ProcessType, error) {
 	procfile := dryccAPI.ProcessType{}
-	file := fmt.Sprintf("%s/Procfile", dirName)
-	if _, err := os.Stat(file); err == nil {
-		rawProcFile, err := os.ReadFile(file)
-		if err != nil {
-			return nil, fmt.Errorf("error in reading %s (%s)", file, err)
-		}
-		if err := yaml.Unmarshal(rawProcFile, &procfile); err != nil {
-			return nil, fmt.Errorf("procfile %s is malformed (%s)", file, err)
-		}
+	rawProcFile, err := fs.ReadFile(files, "Procfile")
+	if errors.Is(err, fs.ErrNotExist) {
+		return procfile, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error in reading Procfile (%s)", err)
+	}
+	if err := yaml.Unmarshal(rawProcFile, &procfile); err != nil {
+		return nil, fmt.Errorf("Procfile is malformed (%s)", err)
 	}
 	return procfile, nil
 }
 
-func getDockerfile(dirName string, stack map[string]string) (string, error) {
-	if stack["name"] == "container" {
-		file := fmt.Sprintf("%s/Dockerfile", dirName)
-		if _, err := os.Stat(file); err == nil {
-			rawDockerfile, err := os.ReadFile(file)
-			if err != nil {
-				return "", fmt.Errorf("error in reading %s (%s)", file, err)
-			}
-			return string(rawDockerfile), nil
+func getDockerfile(files fs.FS, stack Stack) (string, error) {
+	if stack.Name == "container" {
+		rawDockerfile, err := fs.ReadFile(files, "Dockerfile")
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		} else if err != nil {
+			return "", fmt.Errorf("error in reading Dockerfile (%s)", err)
 		}
+		return string(rawDockerfile), nil
 	}
 	return "", nil
 }
+
+// parseDryccfile parses the .drycc directory of files. It's copied to a temporary directory
+// first, as drycc.ParseDryccfile only reads from disk.
+func parseDryccfile(files fs.FS) (map[string]any, error) {
+	tmpDir, err := os.MkdirTemp("", "dryccfile")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create tmpdir (%s)", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dryccDir := filepath.Join(tmpDir, ".drycc")
+	if _, err := fs.Stat(files, ".drycc"); err == nil {
+		dryccFiles, err := fs.Sub(files, ".drycc")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.CopyFS(dryccDir, dryccFiles); err != nil {
+			return nil, fmt.Errorf("error in reading .drycc (%s)", err)
+		}
+	}
+	return drycc.ParseDryccfile(dryccDir)
+}
@@ -3,14 +3,17 @@ package gitreceive
 import (
 	"bytes"
 	"context"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"testing"
+	"testing/fstest"
 
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	builderconf "github.com/drycc/builder/pkg/conf"
+	"github.com/drycc/builder/pkg/git"
 	"github.com/drycc/builder/pkg/sys"
 	"github.com/drycc/controller-sdk-go/api"
 	"github.com/drycc/pkg/log"
@@ -51,28 +54,28 @@ func TestBuild(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := build(config, storageDriver, nil, env, sha); err == nil {
+	if err := build(config, storageDriver, nil, env, nil, nil, sha); err == nil {
 		t.Error("expected running build() without setting config.ImagebuilderImagePullPolicy to fail")
 	}
 
 	config.ImagebuilderImagePullPolicy = "Always"
-	if err := build(config, storageDriver, nil, env, sha); err == nil {
+	if err := build(config, storageDriver, nil, env, nil, nil, sha); err == nil {
 		t.Error("expected running build() without setting config.ImagebuilderImagePullPolicy to fail")
 	}
 
-	err = build(config, storageDriver, nil, env, "abc123")
+	err = build(config, storageDriver, nil, env, nil, nil, "abc123")
 	expected := "git sha abc123 was invalid"
 	if err.Error() != expected {
 		t.Errorf("expected '%s', got '%v'", expected, err.Error())
 	}
 
-	if err := build(config, storageDriver, nil, env, sha); err == nil {
+	if err := build(config, storageDriver, nil, env, nil, nil, sha); err == nil {
 		t.Error("expected running build() without valid controller client info to fail")
 	}
 
 	config.ControllerURL = "http://localhost:1234"
 
-	if err := build(config, storageDriver, nil, env, sha); err == nil {
+	if err := build(config, storageDriver, nil, env, nil, nil, sha); err == nil {
 		t.Error("expected running build() without a valid builder key to fail")
 	}
 
@@ -82,7 +85,7 @@ func TestBuild(t *testing.T) {
 		t.Fatalf("error creating %s (%s)", builderconf.ServiceKeyLocation, err)
 	}
 
-	if err := build(config, storageDriver, nil, env, sha); err == nil {
+	if err := build(config, storageDriver, nil, env, nil, nil, sha); err == nil {
 		t.Error("expected running build() without a valid controller connection to fail")
 	}
 }
@@ -108,7 +111,7 @@ func TestGetProcfileFromRepoSuccess(t *testing.T) {
 			t.Fatalf("failed to remove Procfile from %s (%s)", tmpDir, err)
 		}
 	}()
-	procType, err := getProcfile(tmpDir)
+	procType, err := getProcfile(os.DirFS(tmpDir))
 	actualData := api.ProcessType{}
 	yaml.Unmarshal(data, &actualData)
 	assert.Equal(t, err, nil)
@@ -129,19 +132,133 @@ func TestGetProcfileFromRepoFailure(t *testing.T) {
 			t.Fatalf("failed to remove Procfile from %s (%s)", tmpDir, err)
 		}
 	}()
-	_, err = getProcfile(tmpDir)
+	_, err = getProcfile(os.DirFS(tmpDir))
 
 	assert.True(t, err != nil, "no error received when there should have been")
 }
 
 func TestGetProcfileFromServerSuccess(t *testing.T) {
 	data := []byte("")
-	expect, _ := getProcfile("")
+	expect, _ := getProcfile(fstest.MapFS{})
 	actualData := api.ProcessType{}
 	yaml.Unmarshal(data, &actualData)
 	assert.Equal(t, expect, actualData)
 }
 
+func TestGetDockerfile(t *testing.T) {
+	files := fstest.MapFS{"Dockerfile": &fstest.MapFile{Data: []byte("FROM scratch")}}
+	dockerfile, err := getDockerfile(files, Stack{Name: "container"})
+	assert.Nil(t, err)
+	assert.Equal(t, dockerfile, "FROM scratch", "Dockerfile of a container build")
+
+	dockerfile, err = getDockerfile(files, Stack{Name: "buildpack"})
+	assert.Nil(t, err)
+	assert.Equal(t, dockerfile, "", "Dockerfile of a buildpack build")
+
+	dockerfile, err = getDockerfile(fstest.MapFS{}, Stack{Name: "container"})
+	assert.Nil(t, err)
+	assert.Equal(t, dockerfile, "", "missing Dockerfile")
+}
+
+func TestParseDryccfile(t *testing.T) {
+	_, err := parseDryccfile(fstest.MapFS{})
+	assert.Nil(t, err, "missing .drycc")
+	_, err = parseDryccfile(fstest.MapFS{".drycc/config.yaml": &fstest.MapFile{Data: []byte("config: {}")}})
+	assert.Nil(t, err, ".drycc")
+}
+
+// commitRepo commits the files of repoDir to a new repo there, and returns the sha of the commit.
+func commitRepo(t *testing.T, repoDir string) *git.SHA {
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "--all"},
+		{"commit", "--quiet", "--message", "monorepo"},
+	} {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=drycc", "-c", "user.email=drycc@example.com"}, args...)...)
+		cmd.Dir = repoDir
+		out, err := cmd.CombinedOutput()
+		assert.Nil(t, err, "git %s: %s", args, out)
+	}
+	out, err := repoCmd(repoDir, "git", "rev-parse", "HEAD").Output()
+	assert.Nil(t, err)
+	sha, err := git.NewSha(string(bytes.TrimSpace(out)))
+	assert.Nil(t, err)
+	return sha
+}
+
+func TestGitSourceSubtree(t *testing.T) {
+	repoDir := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(repoDir, "web"), 0o755))
+	assert.Nil(t, os.WriteFile(filepath.Join(repoDir, "web", "Procfile"), []byte("web: ./server"), 0o644))
+	assert.Nil(t, os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("readme"), 0o644))
+	sha := commitRepo(t, repoDir)
+
+	src := gitSource(repoDir, sha, "")
+	_, err := fs.Stat(src.files, "web/Procfile")
+	assert.Nil(t, err, "Procfile under web")
+
+	sub := gitSource(repoDir, sha, "web")
+	assert.Equal(t, sub.version, sha.Full(), "version of a subtree")
+	procfile, err := getProcfile(sub.files)
+	assert.Nil(t, err)
+	assert.Equal(t, procfile, api.ProcessType{"web": "./server"}, "Procfile at the top of the subtree")
+	archive := new(bytes.Buffer)
+	assert.Nil(t, sub.archive(archive))
+	extracted := t.TempDir()
+	assert.Nil(t, extractSourceFiles(archive, extracted))
+	_, err = os.Stat(filepath.Join(extracted, "Procfile"))
+	assert.Nil(t, err, "Procfile at the top of the subtree archive")
+
+	checkedOut, removeCheckout, err := sub.checkout(git.CheckoutOptions{})
+	assert.Nil(t, err)
+	defer removeCheckout()
+	assert.Nil(t, checkedOut.checkout, "a checkout can't be checked out again")
+	procfile, err = getProcfile(checkedOut.files)
+	assert.Nil(t, err)
+	assert.Equal(t, procfile, api.ProcessType{"web": "./server"}, "Procfile at the top of the checked out subtree")
+}
+
+func TestGitSourceCheckoutSymlinks(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "token")
+	assert.Nil(t, os.WriteFile(secret, []byte("secret"), 0o600))
+	repoDir := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(repoDir, "web", "conf"), 0o755))
+	assert.Nil(t, os.WriteFile(filepath.Join(repoDir, "web", "conf", "Procfile"), []byte("web: ./server"), 0o644))
+	assert.Nil(t, os.Symlink("conf/Procfile", filepath.Join(repoDir, "web", "Procfile")))
+	assert.Nil(t, os.Symlink(secret, filepath.Join(repoDir, "web", "Dockerfile")))
+	assert.Nil(t, os.Symlink("../README.md", filepath.Join(repoDir, "web", "README.md")))
+	assert.Nil(t, os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("readme"), 0o644))
+	sha := commitRepo(t, repoDir)
+
+	checkedOut, removeCheckout, err := gitSource(repoDir, sha, "web").checkout(git.CheckoutOptions{})
+	assert.Nil(t, err)
+	defer removeCheckout()
+	procfile, err := getProcfile(checkedOut.files)
+	assert.Nil(t, err)
+	assert.Equal(t, procfile, api.ProcessType{"web": "./server"}, "Procfile linked in the checkout")
+	dockerfile, err := getDockerfile(checkedOut.files, Stack{Name: "container"})
+	assert.NotNil(t, err, "Dockerfile linked out of the checkout")
+	assert.NotContains(t, dockerfile, "secret")
+	_, err = fs.ReadFile(checkedOut.files, "README.md")
+	assert.NotNil(t, err, "README.md linked out of the source directory")
+}
+
+func TestRecordSourceTree(t *testing.T) {
+	storageDriver, err := factory.Create(context.Background(), "inmemory", nil)
+	assert.Nil(t, err)
+	const treeKey = "/home/demo/tree"
+
+	recordSourceTree(storageDriver, treeKey, "")
+	recordSourceTree(storageDriver, treeKey, "4b825dc642cb6eb9a060e54bf8d69288fbee4904")
+	treeID, err := storageDriver.GetContent(context.Background(), treeKey)
+	assert.Nil(t, err)
+	assert.Equal(t, string(treeID), "4b825dc642cb6eb9a060e54bf8d69288fbee4904", "recorded tree")
+
+	recordSourceTree(storageDriver, treeKey, "")
+	_, err = storageDriver.GetContent(context.Background(), treeKey)
+	assert.NotNil(t, err, "forgotten tree")
+}
+
 func TestPrettyPrintJSON(t *testing.T) {
 	f := testJSONStruct{Foo: "bar"}
 	output, err := prettyPrintJSON(f)
@@ -216,3 +333,22 @@ func TestBuildBuilderPodNodeSelector(t *testing.T) {
 	_, err := buildBuilderPodNodeSelector("invalidformat")
 	assert.NotEqual(t, err, nil, "invalid format")
 }
+
+func TestGlobalConfigValues(t *testing.T) {
+	values := []api.ConfigValue{
+		{Group: "global", ConfigVar: api.ConfigVar{Name: "KEY", Value: "VALUE"}},
+		{Group: "global", ConfigVar: api.ConfigVar{Name: "PORT", Value: 5000}},
+		{Group: "web", ConfigVar: api.ConfigVar{Name: "WEB", Value: "ONLY"}},
+	}
+	assert.Equal(t, globalConfigValues(values), map[string]string{"KEY": "VALUE", "PORT": "5000"})
+}
+
+func TestWithForgeStatus(t *testing.T) {
+	config := &Config{}
+	assert.Nil(t, withForgeStatus(nil, config, map[string]string{}), "no forge configured")
+	assert.Nil(t, withForgeStatus(nil, config, map[string]string{"DRYCC_FORGE_REPOSITORY": "drycc/builder"}), "missing token")
+	assert.NotNil(t, withForgeStatus(nil, config, map[string]string{
+		"DRYCC_FORGE_REPOSITORY": "drycc/builder",
+		"DRYCC_FORGE_TOKEN":      "token",
+	}), "forge configured")
+}
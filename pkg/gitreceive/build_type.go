@@ -1,12 +1,24 @@
 package gitreceive
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/drycc/builder/pkg/k8s"
 	"github.com/drycc/controller-sdk-go/api"
 	"github.com/drycc/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 // defaultStacks is default stacks json, order represents priority
@@ -22,25 +34,223 @@ var defaultStacks = `[
 
 ]`
 
-// Stacks for drycc
-var Stacks []map[string]string
+// Stack is a way of building apps, as defined in images.json.
+type Stack struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	// ImagePullPolicy overrides IMAGEBUILDER_IMAGE_PULL_POLICY for the image of the stack.
+	ImagePullPolicy string `json:"imagePullPolicy,omitempty"`
+	// Resources are the resources of the container building with the stack.
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// NodeSelector is merged into BUILDER_POD_NODE_SELECTOR, its labels win.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// ServiceAccount is the service account of the pods building with the stack.
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	// ImagePullSecrets are the secrets pulling the image of the stack, from a private registry.
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+	// SecurityProfile overrides BUILDER_POD_SECURITY_PROFILE for the stacks which support it.
+	SecurityProfile string `json:"securityProfile,omitempty"`
+	// SecurityContext is the security context of the custom SecurityProfile.
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+	// ActiveDeadlineSeconds overrides BUILDER_POD_ACTIVE_DEADLINE_SECONDS for the stack.
+	ActiveDeadlineSeconds int64 `json:"activeDeadlineSeconds,omitempty"`
+	// Scheduling adds tolerations to the ones of the builder, and replaces its other settings.
+	Scheduling
+	// Detect selects the stack for apps which don't choose one with DRYCC_STACK. Stacks without
+	// it are only selected by name, or as the default.
+	Detect *Detection `json:"detect,omitempty"`
+}
+
+// Detection tells which sources a Stack builds.
+type Detection struct {
+	// Rules match sources the stack builds, any of them is enough.
+	Rules []DetectionRule `json:"rules"`
+	// Priority orders the stacks whose rules match the same source, the highest wins. Ties go to
+	// the stack listed first.
+	Priority int `json:"priority"`
+}
+
+// DetectionRule matches sources with a file matching a glob, and whose content matches a regular
+// expression if any.
+type DetectionRule struct {
+	// File is a glob, as in path.Match, relative to the top of the source.
+	File string `json:"file"`
+	// Content is a regular expression which the content of the file must match.
+	Content string `json:"content,omitempty"`
+}
+
+// builtinDetections are the detections of the stacks named after the builtin ones, when
+// images.json doesn't define any.
+var builtinDetections = map[string]*Detection{
+	"container": {Rules: []DetectionRule{{File: "Dockerfile"}}, Priority: 20},
+	"buildpack": {Rules: []DetectionRule{{File: "Procfile"}, {File: "project.toml"}}, Priority: 10},
+}
+
+// stacksFile is the images.json of the imagebuilder-config ConfigMap.
+var stacksFile = imagebuilderConfigPath + "/images.json"
+
+var (
+	// Stacks for drycc
+	Stacks   []Stack
+	stacksMu sync.RWMutex
+)
+
+// LoadStacks loads Stacks from images.json, or from defaultStacks if there's no images.json. It
+// returns an error, and leaves Stacks as they were, if images.json is invalid.
+func LoadStacks() error {
+	data, err := readStacksFile(stacksFile)
+	if err != nil {
+		return err
+	}
+	stacks, err := parseStacks(data)
+	if err != nil {
+		return fmt.Errorf("invalid %s (%s)", stacksFile, err)
+	}
+	stacksMu.Lock()
+	Stacks = stacks
+	stacksMu.Unlock()
+	return nil
+}
+
+// CheckStacks validates images.json every pollSleepDuration when it changed, which happens when
+// the imagebuilder-config ConfigMap is updated, and logs whether it's valid. It doesn't change
+// Stacks: each build runs in its own process, which loads images.json as it is then.
+func CheckStacks(pollSleepDuration time.Duration) {
+	last, _ := readStacksFile(stacksFile)
+	for {
+		time.Sleep(pollSleepDuration)
+		data, err := readStacksFile(stacksFile)
+		if err != nil {
+			log.Err("Error reading the stacks (%s)", err)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		if _, err := parseStacks(data); err != nil {
+			log.Err("Invalid %s, builds will fail until it's fixed (%s)", stacksFile, err)
+			continue
+		}
+		log.Info("The stacks of %s changed, the next builds will use them", stacksFile)
+	}
+}
+
+// readStacksFile returns the content of file, or defaultStacks if it doesn't exist.
+func readStacksFile(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []byte(defaultStacks), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s (%s)", file, err)
+	}
+	return data, nil
+}
+
+// parseStacks parses and validates the stacks of an images.json.
+func parseStacks(data []byte) ([]Stack, error) {
+	var stacks []Stack
+	if err := json.Unmarshal(data, &stacks); err != nil {
+		return nil, err
+	}
+	if len(stacks) == 0 {
+		return nil, errors.New("no stacks defined")
+	}
+	names := make(map[string]bool, len(stacks))
+	for i := range stacks {
+		stack := &stacks[i]
+		if stack.Name == "" {
+			return nil, fmt.Errorf("stack %d has no name", i)
+		}
+		if names[stack.Name] {
+			return nil, fmt.Errorf("stack %s is defined more than once", stack.Name)
+		}
+		names[stack.Name] = true
+		if err := stack.validate(); err != nil {
+			return nil, fmt.Errorf("stack %s %s", stack.Name, err)
+		}
+		if stack.Detect == nil {
+			stack.Detect = builtinDetections[stack.Name]
+		}
+	}
+	return stacks, nil
+}
 
-// initStack load stack by config
-func initStack() error {
-	data, err := os.ReadFile("/etc/imagebuilder/images.json")
-	if err == nil {
-		return json.Unmarshal(data, &Stacks)
+// validate returns an error, completing the sentence "stack <name> ...", if s is invalid.
+func (s Stack) validate() error {
+	if s.Image == "" {
+		return errors.New("has no image")
+	}
+	if s.ImagePullPolicy != "" {
+		if _, err := k8s.PullPolicyFromString(s.ImagePullPolicy); err != nil {
+			return fmt.Errorf("has an invalid imagePullPolicy (%s)", err)
+		}
+	}
+	for key := range s.NodeSelector {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("has an invalid nodeSelector label %q (%s)", key, strings.Join(errs, ", "))
+		}
+	}
+	if s.ServiceAccount != "" {
+		if errs := validation.IsDNS1123Subdomain(s.ServiceAccount); len(errs) > 0 {
+			return fmt.Errorf("has an invalid serviceAccount %q (%s)", s.ServiceAccount, strings.Join(errs, ", "))
+		}
+	}
+	for _, name := range s.ImagePullSecrets {
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return fmt.Errorf("has an invalid imagePullSecret %q (%s)", name, strings.Join(errs, ", "))
+		}
+	}
+	if err := validSecurityProfile(s.SecurityProfile, s.SecurityContext); err != nil {
+		return err
+	}
+	if s.ActiveDeadlineSeconds < 0 {
+		return fmt.Errorf("has a negative activeDeadlineSeconds %d", s.ActiveDeadlineSeconds)
+	}
+	if err := s.Scheduling.validate(); err != nil {
+		return err
 	}
+	if s.Detect == nil {
+		return nil
+	}
+	for _, rule := range s.Detect.Rules {
+		if rule.File == "" {
+			return errors.New("has a detection rule without file")
+		}
+		if _, err := path.Match(rule.File, ""); err != nil {
+			return fmt.Errorf("has an invalid detection file %s (%s)", rule.File, err)
+		}
+		if _, err := regexp.Compile(rule.Content); err != nil {
+			return fmt.Errorf("has an invalid detection content %s (%s)", rule.Content, err)
+		}
+	}
+	return nil
+}
 
-	return json.Unmarshal([]byte(defaultStacks), &Stacks)
+// loadedStacks returns Stacks, loading them first if they aren't.
+func loadedStacks() ([]Stack, error) {
+	stacksMu.RLock()
+	stacks := Stacks
+	stacksMu.RUnlock()
+	if len(stacks) > 0 {
+		return stacks, nil
+	}
+	if err := LoadStacks(); err != nil {
+		return nil, err
+	}
+	stacksMu.RLock()
+	defer stacksMu.RUnlock()
+	return Stacks, nil
 }
 
-func getStack(dirName string, config api.Config) map[string]string {
-	if len(Stacks) == 0 {
-		initStack()
+// getStack returns the stack building files for an app configured with config, along with the
+// reason why it was chosen.
+func getStack(files fs.FS, config api.Config) (Stack, string, error) {
+	stacks, err := loadedStacks()
+	if err != nil {
+		return Stack{}, "", err
 	}
-	log.Debug("Stacks: %s", Stacks)
-	log.Debug("Config values %s", config.Values)
+	log.Debug("Stacks: %v", stacks)
 	strStack := ""
 	for _, v := range config.Values {
 		if v.Group == "global" && v.Name == "DRYCC_STACK" {
@@ -48,32 +258,75 @@ func getStack(dirName string, config api.Config) map[string]string {
 		}
 	}
 
-	for _, stack := range Stacks {
-		if stack["name"] == strStack {
-			return stack
+	for _, stack := range stacks {
+		if stack.Name == strStack {
+			return stack, "set by DRYCC_STACK", nil
 		}
 	}
 
-	if _, err := os.Stat(fmt.Sprintf("%s/Dockerfile", dirName)); err == nil {
-		for _, stack := range Stacks {
-			if stack["name"] == "container" {
-				return stack
+	// sort.SliceStable keeps the order of images.json for stacks of the same priority
+	candidates := make([]Stack, 0, len(stacks))
+	for _, stack := range stacks {
+		if stack.Detect != nil {
+			candidates = append(candidates, stack)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Detect.Priority > candidates[j].Detect.Priority
+	})
+	for _, stack := range candidates {
+		for _, rule := range stack.Detect.Rules {
+			if reason, ok := rule.match(files); ok {
+				return stack, reason, nil
 			}
 		}
 	}
+	return stacks[0], "default stack", nil
+}
 
-	if _, err := os.Stat(fmt.Sprintf("%s/Procfile", dirName)); err == nil {
-		for _, stack := range Stacks {
-			if stack["name"] == "buildpack" {
-				return stack
+// match returns whether a file of files matches r, and the reason why it does.
+func (r DetectionRule) match(files fs.FS) (string, bool) {
+	names, err := fs.Glob(files, r.File)
+	if err != nil {
+		log.Info("Ignoring the invalid stack detection file %s (%s)", r.File, err)
+		return "", false
+	}
+	if r.Content == "" {
+		for _, name := range names {
+			if info, err := fs.Stat(files, name); err == nil && !info.IsDir() {
+				return fmt.Sprintf("found %s", name), true
 			}
 		}
-	} else if _, err := os.Stat(fmt.Sprintf("%s/project.toml", dirName)); err == nil {
-		for _, stack := range Stacks {
-			if stack["name"] == "buildpack" {
-				return stack
+		return "", false
+	}
+	content, err := regexp.Compile(r.Content)
+	if err != nil {
+		log.Info("Ignoring the invalid stack detection content %s (%s)", r.Content, err)
+		return "", false
+	}
+	for _, name := range names {
+		data, err := fs.ReadFile(files, name)
+		if err == nil && content.Match(data) {
+			return fmt.Sprintf("%s matches %s", name, r.Content), true
+		}
+	}
+	return "", false
+}
+
+// matchesDetectionFile returns whether name, a path relative to the top of a source, matches the
+// file of a stack detection rule.
+func matchesDetectionFile(name string) bool {
+	// an invalid images.json fails the build when choosing the stack
+	stacks, _ := loadedStacks()
+	for _, stack := range stacks {
+		if stack.Detect == nil {
+			continue
+		}
+		for _, rule := range stack.Detect.Rules {
+			if matched, err := path.Match(rule.File, name); err == nil && matched {
+				return true
 			}
 		}
 	}
-	return Stacks[0]
+	return false
 }
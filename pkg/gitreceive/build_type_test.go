@@ -1,26 +1,30 @@
 package gitreceive
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"testing/fstest"
 
 	"github.com/drycc/controller-sdk-go/api"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestGetStack(t *testing.T) {
 	tmpDir := os.TempDir()
 	config := api.Config{}
-	stack := getStack(tmpDir, config)
-	if stack["name"] != "buildpack" {
-		t.Fatalf("expected procfile build, got %s", stack)
+	stack, _, _ := getStack(os.DirFS(tmpDir), config)
+	if stack.Name != "buildpack" {
+		t.Fatalf("expected procfile build, got %s", stack.Name)
 	}
 	if _, err := os.Create(tmpDir + "/Dockerfile"); err != nil {
 		t.Fatalf("error creating %s/Dockerfile (%s)", tmpDir, err)
 	}
 
-	stack = getStack(tmpDir, config)
-	if stack["name"] != "container" {
-		t.Fatalf("expected dockerfile build, got %s", stack)
+	stack, _, _ = getStack(os.DirFS(tmpDir), config)
+	if stack.Name != "container" {
+		t.Fatalf("expected dockerfile build, got %s", stack.Name)
 	}
 
 	if _, err := os.Create(tmpDir + "/Procfile"); err != nil {
@@ -44,9 +48,9 @@ func TestGetStack(t *testing.T) {
 			},
 		},
 	}
-	stack = getStack(tmpDir, config)
-	if stack["name"] != "buildpack" {
-		t.Fatalf("expected procfile build, got %s", stack)
+	stack, _, _ = getStack(os.DirFS(tmpDir), config)
+	if stack.Name != "buildpack" {
+		t.Fatalf("expected procfile build, got %s", stack.Name)
 	}
 
 	config.Values = []api.ConfigValue{
@@ -58,8 +62,154 @@ func TestGetStack(t *testing.T) {
 			},
 		},
 	}
-	stack = getStack(tmpDir, config)
-	if stack["name"] != "container" {
-		t.Fatalf("expected Dockerfile build, got %s", stack)
+	stack, _, _ = getStack(os.DirFS(tmpDir), config)
+	if stack.Name != "container" {
+		t.Fatalf("expected Dockerfile build, got %s", stack.Name)
 	}
 }
+
+func TestGetStackDetection(t *testing.T) {
+	defer func(stacks []Stack) { Stacks = stacks }(Stacks)
+	Stacks = []Stack{
+		{Name: "buildpack", Image: "imagebuilder"},
+		{Name: "container", Image: "imagebuilder", Detect: builtinDetections["container"]},
+		{Name: "ko", Image: "ko", Detect: &Detection{
+			Rules:    []DetectionRule{{File: "go.mod", Content: `(?m)^module \S+`}},
+			Priority: 30,
+		}},
+		{Name: "nixpacks", Image: "nixpacks", Detect: &Detection{
+			Rules:    []DetectionRule{{File: "*.nix"}},
+			Priority: 20,
+		}},
+	}
+	file := func(data string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(data)} }
+
+	stack, reason, err := getStack(fstest.MapFS{"Procfile": file("web: app")}, api.Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, "buildpack", stack.Name)
+	assert.Equal(t, "default stack", reason)
+
+	stack, reason, _ = getStack(fstest.MapFS{"Dockerfile": file("FROM scratch")}, api.Config{})
+	assert.Equal(t, "container", stack.Name)
+	assert.Equal(t, "found Dockerfile", reason)
+
+	stack, reason, _ = getStack(fstest.MapFS{
+		"Dockerfile": file("FROM scratch"),
+		"go.mod":     file("module example.com/app\n"),
+	}, api.Config{})
+	assert.Equal(t, "ko", stack.Name)
+	assert.Equal(t, "go.mod matches (?m)^module \\S+", reason)
+
+	stack, reason, _ = getStack(fstest.MapFS{
+		"Dockerfile": file("FROM scratch"),
+		"go.mod":     file("go 1.21\n"),
+	}, api.Config{})
+	assert.Equal(t, "container", stack.Name, "content which doesn't match the regular expression")
+	assert.Equal(t, "found Dockerfile", reason)
+
+	// container and nixpacks have the same priority, the stack listed first wins
+	stack, reason, _ = getStack(fstest.MapFS{
+		"Dockerfile":  file("FROM scratch"),
+		"default.nix": file("{}"),
+	}, api.Config{})
+	assert.Equal(t, "container", stack.Name)
+	assert.Equal(t, "found Dockerfile", reason)
+
+	stack, reason, _ = getStack(fstest.MapFS{"shell.nix": file("{}")}, api.Config{})
+	assert.Equal(t, "nixpacks", stack.Name)
+	assert.Equal(t, "found shell.nix", reason)
+
+	stack, reason, _ = getStack(fstest.MapFS{"go.mod": file("module example.com/app\n")}, api.Config{
+		Values: []api.ConfigValue{
+			{Group: "global", ConfigVar: api.ConfigVar{Name: "DRYCC_STACK", Value: "nixpacks"}},
+		},
+	})
+	assert.Equal(t, "nixpacks", stack.Name)
+	assert.Equal(t, "set by DRYCC_STACK", reason)
+
+	assert.True(t, matchesDetectionFile("go.mod"))
+	assert.True(t, matchesDetectionFile("default.nix"))
+	assert.False(t, matchesDetectionFile("web/default.nix"))
+	assert.False(t, matchesDetectionFile("main.go"))
+}
+
+func TestParseStacks(t *testing.T) {
+	stacks, err := parseStacks([]byte(`[
+		{"name": "buildpack", "image": "imagebuilder"},
+		{
+			"name": "ko",
+			"image": "ko",
+			"imagePullPolicy": "IfNotPresent",
+			"resources": {"limits": {"cpu": "2", "memory": "4Gi"}},
+			"nodeSelector": {"kubernetes.io/arch": "arm64"},
+			"serviceAccount": "ko-builder",
+			"tolerations": [{"key": "spot", "operator": "Exists"}],
+			"detect": {"rules": [{"file": "go.mod"}], "priority": 30}
+		}
+	]`))
+	assert.NoError(t, err)
+	assert.Len(t, stacks, 2)
+	assert.Equal(t, builtinDetections["buildpack"], stacks[0].Detect, "builtin detection")
+	assert.Equal(t, "IfNotPresent", stacks[1].ImagePullPolicy)
+	assert.Equal(t, "4Gi", stacks[1].Resources.Limits.Memory().String())
+	assert.Equal(t, map[string]string{"kubernetes.io/arch": "arm64"}, stacks[1].NodeSelector)
+	assert.Equal(t, "ko-builder", stacks[1].ServiceAccount)
+	assert.Equal(t, "spot", stacks[1].Tolerations[0].Key)
+	assert.Equal(t, 30, stacks[1].Detect.Priority)
+
+	invalid := map[string]string{
+		`{"name": "buildpack"}`:   "cannot unmarshal",
+		`[]`:                      "no stacks defined",
+		`[{"image": "img"}]`:      "stack 0 has no name",
+		`[{"name": "buildpack"}]`: "stack buildpack has no image",
+		`[{"name": "a", "image": "img"}, {"name": "a", "image": "img"}]`:                        "stack a is defined more than once",
+		`[{"name": "a", "image": "img", "imagePullPolicy": "Sometimes"}]`:                       "stack a has an invalid imagePullPolicy",
+		`[{"name": "a", "image": "img", "resources": {"limits": {"cpu": "x"}}}]`:                "quantities must match",
+		`[{"name": "a", "image": "img", "nodeSelector": {"a b": "c"}}]`:                         "stack a has an invalid nodeSelector label",
+		`[{"name": "a", "image": "img", "serviceAccount": "Builder"}]`:                          "stack a has an invalid serviceAccount",
+		`[{"name": "a", "image": "img", "priorityClassName": "A"}]`:                             "stack a has an invalid priorityClassName",
+		`[{"name": "a", "image": "img", "securityProfile": "custom"}]`:                          "stack a has the custom securityProfile without securityContext",
+		`[{"name": "a", "image": "img", "imagePullSecrets": ["Registry"]}]`:                     "stack a has an invalid imagePullSecret",
+		`[{"name": "a", "image": "img", "activeDeadlineSeconds": -1}]`:                          "stack a has a negative activeDeadlineSeconds",
+		`[{"name": "a", "image": "img", "detect": {"rules": [{}]}}]`:                            "stack a has a detection rule without file",
+		`[{"name": "a", "image": "img", "detect": {"rules": [{"file": "["}]}}]`:                 "stack a has an invalid detection file",
+		`[{"name": "a", "image": "img", "detect": {"rules": [{"file": "f", "content": "("}]}}]`: "stack a has an invalid detection content",
+	}
+	for data, msg := range invalid {
+		_, err := parseStacks([]byte(data))
+		if assert.Error(t, err, data) {
+			assert.Contains(t, err.Error(), msg, data)
+		}
+	}
+}
+
+func TestLoadStacks(t *testing.T) {
+	defer func(stacks []Stack, file string) { Stacks, stacksFile = stacks, file }(Stacks, stacksFile)
+	stacksFile = filepath.Join(t.TempDir(), "images.json")
+
+	assert.NoError(t, LoadStacks(), "missing images.json")
+	assert.Equal(t, []string{"buildpack", "container"}, stackNames(Stacks))
+
+	assert.NoError(t, os.WriteFile(stacksFile, []byte(`[{"name": "ko", "image": "ko"}]`), 0644))
+	assert.NoError(t, LoadStacks())
+	assert.Equal(t, []string{"ko"}, stackNames(Stacks))
+
+	assert.NoError(t, os.WriteFile(stacksFile, []byte(`[{"name": "ko"}]`), 0644))
+	err := LoadStacks()
+	if assert.Error(t, err) {
+		assert.Equal(t, fmt.Sprintf("invalid %s (stack ko has no image)", stacksFile), err.Error())
+	}
+	assert.Equal(t, []string{"ko"}, stackNames(Stacks), "the stacks of the last valid images.json")
+
+	Stacks = nil
+	_, _, err = getStack(fstest.MapFS{}, api.Config{})
+	assert.Error(t, err, "the build fails rather than using the default stacks")
+}
+
+func stackNames(stacks []Stack) []string {
+	names := make([]string, 0, len(stacks))
+	for _, stack := range stacks {
+		names = append(names, stack.Name)
+	}
+	return names
+}
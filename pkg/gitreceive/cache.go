@@ -0,0 +1,113 @@
+package gitreceive
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/drycc/builder/pkg/k8s"
+	"github.com/drycc/pkg/log"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// pvcCache caches builds in a PersistentVolumeClaim per app
+	pvcCache = "pvc"
+	// storageCache caches builds in an archive per app and stack in the object storage, which the
+	// imagebuilder restores and saves
+	storageCache = "storage"
+
+	buildCacheKey    = "DRYCC_BUILD_CACHE"
+	buildCacheVolume = "build-cache"
+	buildCacheDir    = "/var/cache/drycc/build"
+)
+
+// BuildCacheKeyPattern is the template for storing the build cache archive of an app and a stack.
+const BuildCacheKeyPattern = "home/%s:cache-%s"
+
+// BuildCacheClaimName returns the name of the PersistentVolumeClaim caching the builds of appName.
+func BuildCacheClaimName(appName string) string {
+	return "imagebuild-cache-" + appName
+}
+
+// buildCache is the cache of the builds of an app with a stack.
+type buildCache struct {
+	// claim is the PersistentVolumeClaim of a pvc cache, and subPath the directory of the stack
+	claim, subPath string
+	// key is the object storage key of a storage cache
+	key string
+}
+
+// builderPodCache returns the cache of the builds of appName with stack, or nil if the app, with
+// the global config values, doesn't enable it with DRYCC_BUILD_CACHE.
+func builderPodCache(conf *Config, appName string, stack Stack, values map[string]string) (*buildCache, error) {
+	if values[buildCacheKey] != "true" {
+		return nil, nil
+	}
+	switch conf.BuildCacheType {
+	case pvcCache:
+		return &buildCache{claim: BuildCacheClaimName(appName), subPath: stack.Name}, nil
+	case storageCache:
+		return &buildCache{key: fmt.Sprintf(BuildCacheKeyPattern, appName, stack.Name)}, nil
+	case "":
+		log.Info("Ignoring %s, this builder doesn't cache builds.", buildCacheKey)
+		return nil, nil
+	}
+	return nil, fmt.Errorf("invalid BUILD_CACHE_TYPE %s", conf.BuildCacheType)
+}
+
+// ensureClaim creates the PersistentVolumeClaim of c, of size and storageClass, unless it exists.
+func (c *buildCache) ensureClaim(pvcs k8s.PersistentVolumeClaimCreator, size, storageClass string) error {
+	if c == nil || c.claim == "" {
+		return nil
+	}
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return fmt.Errorf("invalid BUILD_CACHE_SIZE %s (%s)", size, err)
+	}
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   c.claim,
+			Labels: map[string]string{"heritage": "drycc"},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: quantity},
+			},
+		},
+	}
+	if storageClass != "" {
+		claim.Spec.StorageClassName = &storageClass
+	}
+	if _, err := pvcs.Create(context.TODO(), claim, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// apply mounts c into the build pods of job, and tells the imagebuilder where it is.
+func (c *buildCache) apply(job *batchv1.Job) {
+	if c == nil {
+		return
+	}
+	if c.key != "" {
+		addEnvToJob(*job, "DRYCC_BUILD_CACHE_KEY", c.key)
+		return
+	}
+	job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, corev1.Volume{
+		Name: buildCacheVolume,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: c.claim},
+		},
+	})
+	job.Spec.Template.Spec.Containers[0].VolumeMounts = append(job.Spec.Template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      buildCacheVolume,
+		MountPath: buildCacheDir,
+		SubPath:   c.subPath,
+	})
+	addEnvToJob(*job, "DRYCC_BUILD_CACHE_DIR", buildCacheDir)
+}
@@ -0,0 +1,86 @@
+package gitreceive
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuilderPodCache(t *testing.T) {
+	stack := Stack{Name: "buildpack"}
+	enabled := map[string]string{buildCacheKey: "true"}
+
+	cache, err := builderPodCache(&Config{BuildCacheType: "pvc"}, "demo", stack, map[string]string{})
+	assert.NoError(t, err)
+	assert.Nil(t, cache, "cache not enabled by the app")
+
+	cache, err = builderPodCache(&Config{}, "demo", stack, enabled)
+	assert.NoError(t, err)
+	assert.Nil(t, cache, "builder without cache")
+
+	cache, err = builderPodCache(&Config{BuildCacheType: "pvc"}, "demo", stack, enabled)
+	assert.NoError(t, err)
+	assert.Equal(t, &buildCache{claim: "imagebuild-cache-demo", subPath: "buildpack"}, cache)
+
+	cache, err = builderPodCache(&Config{BuildCacheType: "storage"}, "demo", stack, enabled)
+	assert.NoError(t, err)
+	assert.Equal(t, &buildCache{key: "home/demo:cache-buildpack"}, cache)
+
+	_, err = builderPodCache(&Config{BuildCacheType: "nfs"}, "demo", stack, enabled)
+	assert.Error(t, err)
+}
+
+type fakePVCCreator struct {
+	created []*corev1.PersistentVolumeClaim
+	err     error
+}
+
+func (f *fakePVCCreator) Create(_ context.Context, claim *corev1.PersistentVolumeClaim, _ metav1.CreateOptions) (*corev1.PersistentVolumeClaim, error) {
+	f.created = append(f.created, claim)
+	return claim, f.err
+}
+
+func TestBuildCacheEnsureClaim(t *testing.T) {
+	pvcs := &fakePVCCreator{}
+	cache := &buildCache{claim: "imagebuild-cache-demo", subPath: "buildpack"}
+
+	assert.NoError(t, cache.ensureClaim(pvcs, "5Gi", "fast"))
+	claim := pvcs.created[0]
+	assert.Equal(t, "imagebuild-cache-demo", claim.Name)
+	assert.Equal(t, "5Gi", claim.Spec.Resources.Requests.Storage().String())
+	assert.Equal(t, "fast", *claim.Spec.StorageClassName)
+
+	pvcs.err = apierrors.NewAlreadyExists(corev1.Resource("persistentvolumeclaims"), "imagebuild-cache-demo")
+	assert.NoError(t, cache.ensureClaim(pvcs, "5Gi", "fast"), "existing claim")
+	pvcs.err = errors.New("quota exceeded")
+	assert.EqualError(t, cache.ensureClaim(pvcs, "5Gi", ""), "quota exceeded")
+
+	assert.Error(t, cache.ensureClaim(pvcs, "lots", ""), "invalid size")
+	assert.NoError(t, (*buildCache)(nil).ensureClaim(pvcs, "lots", ""), "no cache")
+	assert.NoError(t, (&buildCache{key: "home/demo:cache-buildpack"}).ensureClaim(pvcs, "lots", ""), "storage cache")
+}
+
+func TestBuildCacheApply(t *testing.T) {
+	job := createBuilderJob(false, "test", "default", "", "tar", "deadbeef", "img", "imagebuilder",
+		Stack{}, nil, corev1.PullAlways, Security{}, nil, corev1.ResourceRequirements{}, Scheduling{}, Identity{})
+	(&buildCache{claim: "imagebuild-cache-demo", subPath: "buildpack"}).apply(job)
+	assert.Equal(t, "imagebuild-cache-demo", job.Spec.Template.Spec.Volumes[1].PersistentVolumeClaim.ClaimName)
+	assert.Equal(t, corev1.VolumeMount{Name: "build-cache", MountPath: buildCacheDir, SubPath: "buildpack"},
+		job.Spec.Template.Spec.Containers[0].VolumeMounts[1])
+	checkForEnv(t, job, "DRYCC_BUILD_CACHE_DIR", buildCacheDir)
+
+	job = &batchv1.Job{Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+		Containers: []corev1.Container{{}},
+	}}}}
+	(&buildCache{key: "home/demo:cache-buildpack"}).apply(job)
+	assert.Empty(t, job.Spec.Template.Spec.Volumes)
+	checkForEnv(t, job, "DRYCC_BUILD_CACHE_KEY", "home/demo:cache-buildpack")
+
+	(*buildCache)(nil).apply(job)
+}
@@ -3,6 +3,10 @@ package gitreceive
 import (
 	"strings"
 	"time"
+
+	"github.com/drycc/builder/pkg/audit"
+	"github.com/drycc/builder/pkg/forge"
+	"github.com/drycc/builder/pkg/notify"
 )
 
 const (
@@ -30,6 +34,48 @@ type Config struct {
 	SessionIdleIntervalMsec       int    `envconfig:"SESSION_IDLE_INTERVAL" default:"10000"`         // 10 seconds
 	ImagebuilderImagePullPolicy   string `envconfig:"IMAGEBUILDER_IMAGE_PULL_POLICY" default:"Always"`
 	BuilderPodNodeSelector        string `envconfig:"BUILDER_POD_NODE_SELECTOR" default:""`
+	BuilderPodCPURequest          string `envconfig:"BUILDER_POD_CPU_REQUEST" default:"500m"`
+	BuilderPodCPULimit            string `envconfig:"BUILDER_POD_CPU_LIMIT" default:""`
+	BuilderPodMemoryRequest       string `envconfig:"BUILDER_POD_MEMORY_REQUEST" default:"512Mi"`
+	BuilderPodMemoryLimit         string `envconfig:"BUILDER_POD_MEMORY_LIMIT" default:""`
+	GitCredentialsFile            string `envconfig:"GIT_CREDENTIALS_FILE" default:""`
+	BuildEnvGlobal                bool   `envconfig:"BUILD_ENV_GLOBAL" default:"false"`
+	Audit                         audit.Config
+	Notify                        notify.Config
+	Forge                         forge.Config
+
+	// BuilderPodTolerations, BuilderPodAffinity and BuilderPodTopologySpreadConstraints are JSON
+	BuilderPodTolerations               string `envconfig:"BUILDER_POD_TOLERATIONS" default:""`
+	BuilderPodAffinity                  string `envconfig:"BUILDER_POD_AFFINITY" default:""`
+	BuilderPodTopologySpreadConstraints string `envconfig:"BUILDER_POD_TOPOLOGY_SPREAD_CONSTRAINTS" default:""`
+	BuilderPodPriorityClassName         string `envconfig:"BUILDER_POD_PRIORITY_CLASS_NAME" default:""`
+
+	// BuilderPodSecurityProfile is privileged, rootless or custom, with the JSON
+	// BuilderPodSecurityContext
+	BuilderPodSecurityProfile string `envconfig:"BUILDER_POD_SECURITY_PROFILE" default:"privileged"`
+	BuilderPodSecurityContext string `envconfig:"BUILDER_POD_SECURITY_CONTEXT" default:""`
+
+	// BuilderPodAppServiceAccounts are the comma separated service accounts which apps may build
+	// with, using DRYCC_BUILD_SERVICE_ACCOUNT
+	BuilderPodAppServiceAccounts string `envconfig:"BUILDER_POD_APP_SERVICE_ACCOUNTS" default:""`
+
+	// BuildCacheType is pvc or storage to cache the builds of apps with DRYCC_BUILD_CACHE, the
+	// size and storage class being the ones of the pvc caches
+	BuildCacheType         string `envconfig:"BUILD_CACHE_TYPE" default:""`
+	BuildCacheSize         string `envconfig:"BUILD_CACHE_SIZE" default:"10Gi"`
+	BuildCacheStorageClass string `envconfig:"BUILD_CACHE_STORAGE_CLASS" default:""`
+
+	// BuilderPodActiveDeadlineSeconds is the activeDeadlineSeconds of the build jobs, 0 letting
+	// them run forever
+	BuilderPodActiveDeadlineSeconds int64 `envconfig:"BUILDER_POD_ACTIVE_DEADLINE_SECONDS" default:"3600"`
+
+	// BuilderPodBackoffLimit is the number of times builds killed, e.g. for running out of
+	// memory, are retried
+	BuilderPodBackoffLimit int32 `envconfig:"BUILDER_POD_BACKOFF_LIMIT" default:"2"`
+
+	// BuildWatcherSocket is the unix socket of the builder server, which builds get the pods and
+	// the events of their job from
+	BuildWatcherSocket string `envconfig:"BUILD_WATCHER_SOCKET" default:"/tmp/drycc-builder-watcher.sock"`
 }
 
 // App returns the application name represented by c. The app name is the same as c.Repository
@@ -42,8 +88,21 @@ func (c Config) App() string {
 	return c.Repository[0:li]
 }
 
+// AppServiceAccounts returns the service accounts of c.BuilderPodAppServiceAccounts.
+func (c Config) AppServiceAccounts() []string {
+	var names []string
+	for _, name := range strings.Split(c.BuilderPodAppServiceAccounts, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 // BuilderPodTickDuration returns the size of the interval used to check for
 // the end of the execution of a Pod building an application.
+//
+// Deprecated: build pods are watched, and checked whenever they change.
 func (c Config) BuilderPodTickDuration() time.Duration {
 	return time.Duration(time.Duration(c.BuilderPodTickDurationMSec) * time.Millisecond)
 }
@@ -0,0 +1,110 @@
+package gitreceive
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedbatchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
+)
+
+// deadlineKey is the app config value overriding the deadline of its builds, in seconds.
+const deadlineKey = "DRYCC_BUILD_DEADLINE"
+
+// deadlineExceeded is the reason of the failure of the jobs running longer than their deadline.
+const deadlineExceeded = "DeadlineExceeded"
+
+// builderPodDeadline returns the number of seconds the build jobs of an app with the global
+// config values may run for, building with stack, 0 being forever. values override stack, which
+// overrides conf.
+func builderPodDeadline(conf *Config, stack Stack, values map[string]string) (int64, error) {
+	deadline := conf.BuilderPodActiveDeadlineSeconds
+	if deadline < 0 {
+		return 0, fmt.Errorf("invalid BUILDER_POD_ACTIVE_DEADLINE_SECONDS %d", deadline)
+	}
+	if stack.ActiveDeadlineSeconds > 0 {
+		deadline = stack.ActiveDeadlineSeconds
+	}
+	if value := values[deadlineKey]; value != "" {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seconds <= 0 {
+			return 0, fmt.Errorf("invalid %s %s, it must be a number of seconds", deadlineKey, value)
+		}
+		deadline = seconds
+	}
+	return deadline, nil
+}
+
+// buildTimedOut returns an error telling that the build job named name ran longer than deadline
+// seconds, if it failed for it, and err otherwise.
+func buildTimedOut(jobs typedbatchv1.JobInterface, name string, deadline int64, err error) error {
+	if deadline == 0 {
+		return err
+	}
+	job, getErr := getJob(jobs, name)
+	if getErr != nil || jobFailure(job) != deadlineExceeded {
+		return err
+	}
+	return &buildFailure{
+		reason:  failureDeadline,
+		message: fmt.Sprintf("build timed out after %s", time.Duration(deadline)*time.Second),
+		hint:    fmt.Sprintf("which %s can change", deadlineKey),
+	}
+}
+
+// getJob returns the job named name.
+func getJob(jobs typedbatchv1.JobInterface, name string) (*batchv1.Job, error) {
+	return jobs.Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+// jobEndWait is how long the builder waits for a build job to be over once it stops watching it,
+// and jobEndInterval how often it checks.
+const (
+	jobEndWait     = 10 * time.Second
+	jobEndInterval = 500 * time.Millisecond
+)
+
+// waitForJobEnd returns whether the job named name completed or failed, or is gone, within
+// timeout. Jobs complete a little after their pod succeeds.
+func waitForJobEnd(jobs typedbatchv1.JobInterface, name string, timeout time.Duration) bool {
+	for end := time.Now().Add(timeout); ; time.Sleep(jobEndInterval) {
+		job, err := getJob(jobs, name)
+		if apierrors.IsNotFound(err) {
+			return true
+		}
+		if err == nil && jobEnded(job) {
+			return true
+		}
+		if time.Now().After(end) {
+			return false
+		}
+	}
+}
+
+// jobEnded returns whether job completed or failed, and won't run pods anymore.
+func jobEnded(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) &&
+			condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// jobFailure returns the reason why job failed, or is failing, e.g. DeadlineExceeded or
+// BackoffLimitExceeded, and "" if it isn't.
+func jobFailure(job *batchv1.Job) string {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobFailed || condition.Type == batchv1.JobFailureTarget) &&
+			condition.Status == corev1.ConditionTrue {
+			return condition.Reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package gitreceive
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestBuilderPodDeadline(t *testing.T) {
+	conf := &Config{BuilderPodActiveDeadlineSeconds: 3600}
+
+	deadline, err := builderPodDeadline(conf, Stack{}, map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3600), deadline, "deadline of the builder")
+
+	deadline, err = builderPodDeadline(conf, Stack{ActiveDeadlineSeconds: 7200}, map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7200), deadline, "deadline of the stack")
+
+	deadline, err = builderPodDeadline(conf, Stack{ActiveDeadlineSeconds: 7200}, map[string]string{deadlineKey: "600"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(600), deadline, "deadline of the app")
+
+	for _, value := range []string{"0", "-1", "1h"} {
+		_, err = builderPodDeadline(conf, Stack{}, map[string]string{deadlineKey: value})
+		assert.EqualError(t, err, "invalid DRYCC_BUILD_DEADLINE "+value+", it must be a number of seconds")
+	}
+	_, err = builderPodDeadline(&Config{BuilderPodActiveDeadlineSeconds: -1}, Stack{}, map[string]string{})
+	assert.Error(t, err)
+}
+
+func TestBuildTimedOut(t *testing.T) {
+	jobs := fake.NewSimpleClientset().BatchV1().Jobs("default")
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	_, err := jobs.Create(context.TODO(), job, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	failed := errors.New("exited with code 1")
+	assert.Equal(t, failed, buildTimedOut(jobs, "test", 600, failed), "running job")
+	assert.NoError(t, buildTimedOut(jobs, "test", 600, nil))
+
+	job.Status.Conditions = []batchv1.JobCondition{{
+		Type:   batchv1.JobFailed,
+		Status: corev1.ConditionTrue,
+		Reason: deadlineExceeded,
+	}}
+	_, err = jobs.UpdateStatus(context.TODO(), job, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.EqualError(t, buildTimedOut(jobs, "test", 600, failed), "build timed out after 10m0s, which DRYCC_BUILD_DEADLINE can change")
+	assert.Error(t, buildTimedOut(jobs, "test", 600, nil))
+	assert.Equal(t, failed, buildTimedOut(jobs, "test", 0, failed), "job without deadline")
+	assert.Equal(t, failed, buildTimedOut(jobs, "other", 600, failed), "missing job")
+}
+
+func TestWaitForJobEnd(t *testing.T) {
+	jobs := fake.NewSimpleClientset().BatchV1().Jobs("default")
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	_, err := jobs.Create(context.TODO(), job, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	assert.False(t, waitForJobEnd(jobs, "test", 0), "running job, whose retries need its secret")
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailureTarget, Status: corev1.ConditionTrue}}
+	_, err = jobs.UpdateStatus(context.TODO(), job, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.False(t, waitForJobEnd(jobs, "test", 0), "failing job")
+
+	go func() {
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		jobs.UpdateStatus(context.TODO(), job, metav1.UpdateOptions{})
+	}()
+	assert.True(t, waitForJobEnd(jobs, "test", 10*time.Second), "completed job")
+	assert.True(t, waitForJobEnd(jobs, "other", 0), "missing job")
+}
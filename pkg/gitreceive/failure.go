@@ -0,0 +1,176 @@
+package gitreceive
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// the reasons of build failures
+const (
+	failureOOMKilled = "OOMKilled"
+	failureEvicted   = "Evicted"
+	failureDeadline  = deadlineExceeded
+	failureImagePull = "ImagePull"
+	failureConfig    = "CreateContainerConfigError"
+	failureExitCode  = "ExitCode"
+	failurePod       = "PodFailed"
+)
+
+// imagePullFailures are the reasons why containers wait for an image which won't be pulled
+// without changing the build, the stack or the cluster. ErrImagePull is retried before.
+var imagePullFailures = map[string]bool{
+	"ImagePullBackOff":  true,
+	"InvalidImageName":  true,
+	"ErrImageNeverPull": true,
+}
+
+// unpullableImageMessages are parts of the messages of image pulls which fail the same way however
+// often they're retried, e.g. for images which don't exist or which the build has no access to.
+var unpullableImageMessages = []string{
+	"not found",
+	"manifest unknown",
+	"unauthorized",
+	"denied",
+	"forbidden",
+	"invalid reference format",
+}
+
+// buildFailure tells why a build failed, and what to do about it.
+type buildFailure struct {
+	// reason is one of the failure* reasons.
+	reason  string
+	message string
+	hint    string
+}
+
+// Error returns the message of f, followed by its hint if any.
+func (f *buildFailure) Error() string {
+	if f.hint == "" {
+		return f.message
+	}
+	return f.message + ", " + f.hint
+}
+
+// podFailure returns why the build of pod failed, and nil if it didn't. The pods disrupted, e.g.
+// evicted or lost with their node, fail whatever their containers did.
+func podFailure(pod *corev1.Pod) *buildFailure {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.DisruptionTarget && condition.Status == corev1.ConditionTrue {
+			return &buildFailure{
+				reason:  failureEvicted,
+				message: fmt.Sprintf("build pod was evicted (%s): %s", condition.Reason, condition.Message),
+				hint:    "which happens when its node runs short of resources or goes away, push again if it isn't retried",
+			}
+		}
+	}
+	switch pod.Status.Reason {
+	case "Evicted":
+		return &buildFailure{
+			reason:  failureEvicted,
+			message: fmt.Sprintf("build pod was evicted: %s", pod.Status.Message),
+			hint:    "which happens when its node runs short of resources, push again if it isn't retried",
+		}
+	case deadlineExceeded:
+		return &buildFailure{
+			reason:  failureDeadline,
+			message: "build pod ran past its deadline",
+			hint:    fmt.Sprintf("which %s can change", deadlineKey),
+		}
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	started := false
+	for _, status := range statuses {
+		started = started || status.State.Running != nil || status.State.Terminated != nil
+		// containers still waiting or running have no exit code
+		if failure := waitingFailure(status); failure != nil {
+			return failure
+		}
+		terminated := status.State.Terminated
+		if terminated == nil || terminated.ExitCode == 0 {
+			continue
+		}
+		if terminated.Reason == failureOOMKilled {
+			return &buildFailure{
+				reason:  failureOOMKilled,
+				message: fmt.Sprintf("build container %s ran out of memory", status.Name),
+				hint:    "raise its memory limit with DRYCC_BUILD_MEMORY_LIMIT",
+			}
+		}
+		hint := "see the build logs above for why"
+		if terminated.ExitCode == 137 {
+			hint = "it was killed, e.g. for running out of memory, which DRYCC_BUILD_MEMORY_LIMIT can fix"
+		}
+		return &buildFailure{
+			reason:  failureExitCode,
+			message: fmt.Sprintf("build container %s exited with code %d", status.Name, terminated.ExitCode),
+			hint:    hint,
+		}
+	}
+
+	if pod.Status.Phase != corev1.PodFailed {
+		return nil
+	}
+	failure := &buildFailure{reason: failurePod, message: "build pod failed"}
+	// pods failing before running were rejected, e.g. by their node running short of resources
+	if !started {
+		failure.message = "build pod failed before running"
+		failure.hint = "which happens when its node rejects it, push again if it isn't retried"
+	}
+	if pod.Status.Reason != "" {
+		failure.message += fmt.Sprintf(" (%s)", pod.Status.Reason)
+	}
+	if pod.Status.Message != "" {
+		failure.message += ": " + pod.Status.Message
+	}
+	return failure
+}
+
+// waitingFailure returns why the container of status won't ever start, and nil if it's not
+// waiting, or may still start.
+func waitingFailure(status corev1.ContainerStatus) *buildFailure {
+	waiting := status.State.Waiting
+	if waiting == nil {
+		return nil
+	}
+	if imagePullFailures[waiting.Reason] {
+		return &buildFailure{
+			reason:  failureImagePull,
+			message: fmt.Sprintf("build image %s can't be pulled (%s): %s", status.Image, waiting.Reason, waiting.Message),
+			hint:    "check that it exists, and that the imagePullSecrets of the stack or DRYCC_BUILD_IMAGE_PULL_SECRETS give access to it",
+		}
+	}
+	if waiting.Reason == failureConfig {
+		return &buildFailure{
+			reason:  failureConfig,
+			message: fmt.Sprintf("build container %s can't be created: %s", status.Name, waiting.Message),
+			hint:    "check that the secrets, config maps and service account of the build exist",
+		}
+	}
+	return nil
+}
+
+// startFailure returns why the container of status of a pod still starting won't ever start, and
+// nil if it may still. The kubelet backs off pulling images, so those failing for reasons which
+// may go away, e.g. registry outages or rate limits, are retried until the deadline of the build.
+func startFailure(status corev1.ContainerStatus) *buildFailure {
+	waiting := status.State.Waiting
+	if waiting != nil && waiting.Reason == "ImagePullBackOff" && !unpullableImage(waiting.Message) {
+		return nil
+	}
+	return waitingFailure(status)
+}
+
+// unpullableImage returns whether message, of a failed image pull, tells the pull won't ever
+// succeed.
+func unpullableImage(message string) bool {
+	message = strings.ToLower(message)
+	for _, unpullable := range unpullableImageMessages {
+		if strings.Contains(message, unpullable) {
+			return true
+		}
+	}
+	return false
+}
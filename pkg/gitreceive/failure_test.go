@@ -0,0 +1,119 @@
+package gitreceive
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPodFailure(t *testing.T) {
+	terminated := func(reason string, exitCode int32) corev1.ContainerStatus {
+		return corev1.ContainerStatus{Name: "drycc-builder", State: corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{Reason: reason, ExitCode: exitCode},
+		}}
+	}
+	tests := []struct {
+		name    string
+		status  corev1.PodStatus
+		reason  string
+		message string
+	}{
+		{
+			name:   "succeeded",
+			status: corev1.PodStatus{Phase: corev1.PodSucceeded, ContainerStatuses: []corev1.ContainerStatus{terminated("Completed", 0)}},
+		},
+		{
+			name:    "out of memory",
+			status:  corev1.PodStatus{Phase: corev1.PodFailed, ContainerStatuses: []corev1.ContainerStatus{terminated("OOMKilled", 137)}},
+			reason:  failureOOMKilled,
+			message: "build container drycc-builder ran out of memory, raise its memory limit with DRYCC_BUILD_MEMORY_LIMIT",
+		},
+		{
+			name:    "build error",
+			status:  corev1.PodStatus{Phase: corev1.PodFailed, ContainerStatuses: []corev1.ContainerStatus{terminated("Error", 2)}},
+			reason:  failureExitCode,
+			message: "build container drycc-builder exited with code 2, see the build logs above for why",
+		},
+		{
+			name: "init container error",
+			status: corev1.PodStatus{
+				Phase:                 corev1.PodFailed,
+				InitContainerStatuses: []corev1.ContainerStatus{terminated("Error", 1)},
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "drycc-builder", State: corev1.ContainerState{
+					Waiting: &corev1.ContainerStateWaiting{Reason: "PodInitializing"},
+				}}},
+			},
+			reason:  failureExitCode,
+			message: "build container drycc-builder exited with code 1, see the build logs above for why",
+		},
+		{
+			name: "evicted",
+			status: corev1.PodStatus{
+				Phase: corev1.PodFailed,
+				Conditions: []corev1.PodCondition{{
+					Type:    corev1.DisruptionTarget,
+					Status:  corev1.ConditionTrue,
+					Reason:  "TerminationByKubelet",
+					Message: "The node was low on resource: memory.",
+				}},
+				ContainerStatuses: []corev1.ContainerStatus{terminated("Error", 137)},
+			},
+			reason: failureEvicted,
+		},
+		{
+			name:   "evicted without condition",
+			status: corev1.PodStatus{Phase: corev1.PodFailed, Reason: "Evicted", Message: "The node was low on resource: ephemeral-storage."},
+			reason: failureEvicted,
+		},
+		{
+			name:   "deadline",
+			status: corev1.PodStatus{Phase: corev1.PodFailed, Reason: deadlineExceeded},
+			reason: failureDeadline,
+		},
+		{
+			name: "image pull",
+			status: corev1.PodStatus{Phase: corev1.PodFailed, ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "drycc-builder",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+			}}},
+			reason: failureImagePull,
+		},
+		{
+			name: "still running",
+			status: corev1.PodStatus{Phase: corev1.PodFailed, Reason: "UnexpectedAdmissionError", ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "drycc-builder",
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			}}},
+			reason:  failurePod,
+			message: "build pod failed (UnexpectedAdmissionError)",
+		},
+		{
+			name: "failed before running",
+			status: corev1.PodStatus{
+				Phase:   corev1.PodFailed,
+				Reason:  "OutOfcpu",
+				Message: "Pod was rejected: Node didn't have enough resource: cpu",
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "drycc-builder",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+				}},
+			},
+			reason:  failurePod,
+			message: "build pod failed before running (OutOfcpu): Pod was rejected: Node didn't have enough resource: cpu, which happens when its node rejects it, push again if it isn't retried",
+		},
+	}
+	for _, test := range tests {
+		failure := podFailure(&corev1.Pod{Status: test.status})
+		if test.reason == "" {
+			assert.Nil(t, failure, test.name)
+			continue
+		}
+		if assert.NotNil(t, failure, test.name) {
+			assert.Equal(t, test.reason, failure.reason, test.name)
+			if test.message != "" {
+				assert.Equal(t, test.message, failure.Error(), test.name)
+			}
+		}
+	}
+}
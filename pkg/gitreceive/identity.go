@@ -0,0 +1,59 @@
+package gitreceive
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	serviceAccountKey   = "DRYCC_BUILD_SERVICE_ACCOUNT"
+	imagePullSecretsKey = "DRYCC_BUILD_IMAGE_PULL_SECRETS"
+)
+
+// Identity is who the build pods run as, and how they pull their image.
+type Identity struct {
+	ServiceAccount   string
+	ImagePullSecrets []corev1.LocalObjectReference
+}
+
+// builderPodIdentity returns the identity of the pods building with stack for an app with the
+// global config values. The service account of the app, which must be one of
+// BUILDER_POD_APP_SERVICE_ACCOUNTS, replaces the one of stack, and the image pull secrets of the app
+// are added to the ones of stack.
+func builderPodIdentity(conf *Config, stack Stack, values map[string]string) (Identity, error) {
+	identity := Identity{ServiceAccount: stack.ServiceAccount}
+	if name := strings.TrimSpace(values[serviceAccountKey]); name != "" {
+		if !slices.Contains(conf.AppServiceAccounts(), name) {
+			return identity, fmt.Errorf("the %s %s isn't allowed, ask an administrator to add it to BUILDER_POD_APP_SERVICE_ACCOUNTS", serviceAccountKey, name)
+		}
+		identity.ServiceAccount = name
+	}
+
+	names := slices.Clone(stack.ImagePullSecrets)
+	for _, name := range strings.Split(values[imagePullSecretsKey], ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return identity, fmt.Errorf("invalid %s %q (%s)", imagePullSecretsKey, name, strings.Join(errs, ", "))
+		}
+		names = append(names, name)
+	}
+	for _, name := range names {
+		secret := corev1.LocalObjectReference{Name: name}
+		if !slices.Contains(identity.ImagePullSecrets, secret) {
+			identity.ImagePullSecrets = append(identity.ImagePullSecrets, secret)
+		}
+	}
+	return identity, nil
+}
+
+// apply sets i in the pod spec of the build pods.
+func (i Identity) apply(spec *corev1.PodSpec) {
+	spec.ServiceAccountName = i.ServiceAccount
+	spec.ImagePullSecrets = i.ImagePullSecrets
+}
@@ -0,0 +1,39 @@
+package gitreceive
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestBuilderPodIdentity(t *testing.T) {
+	conf := &Config{BuilderPodAppServiceAccounts: "mirror-reader, gcr-reader"}
+	stack := Stack{ServiceAccount: "ko-builder", ImagePullSecrets: []string{"registry"}}
+
+	identity, err := builderPodIdentity(conf, stack, map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, Identity{
+		ServiceAccount:   "ko-builder",
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}},
+	}, identity, "identity of the stack")
+
+	identity, err = builderPodIdentity(conf, stack, map[string]string{
+		serviceAccountKey:   "gcr-reader",
+		imagePullSecretsKey: "mirror, registry,",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, Identity{
+		ServiceAccount:   "gcr-reader",
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}, {Name: "mirror"}},
+	}, identity, "identity of the app")
+
+	_, err = builderPodIdentity(conf, stack, map[string]string{serviceAccountKey: "drycc-builder"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "the DRYCC_BUILD_SERVICE_ACCOUNT drycc-builder isn't allowed")
+	}
+	_, err = builderPodIdentity(conf, stack, map[string]string{imagePullSecretsKey: "Mirror"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid DRYCC_BUILD_IMAGE_PULL_SECRETS \"Mirror\"")
+	}
+}
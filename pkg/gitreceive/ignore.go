@@ -0,0 +1,112 @@
+package gitreceive
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"strings"
+)
+
+// ignoreFile is the file listing, in gitignore syntax, the paths of a source to leave out of the
+// tarball handed to imagebuilder. See https://git-scm.com/docs/gitignore#_pattern_format
+const ignoreFile = ".dryccignore"
+
+// ignorePattern is a pattern of an ignoreFile.
+type ignorePattern struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+	// anchored patterns match from the top of the source, others match any basename
+	anchored bool
+}
+
+// ignoreRules are the patterns of an ignoreFile. Like with gitignore, the last matching pattern
+// decides, and nothing under an ignored directory can be included again.
+type ignoreRules struct {
+	patterns []ignorePattern
+}
+
+// parseIgnoreRules parses the ignoreFile read from r.
+func parseIgnoreRules(r io.Reader) (*ignoreRules, error) {
+	rules := &ignoreRules{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			// escapes a leading # or !
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		p.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		p.segments = strings.Split(line, "/")
+		rules.patterns = append(rules.patterns, p)
+	}
+	return rules, scanner.Err()
+}
+
+// ignored returns whether name, a slash separated path relative to the top of the source, is
+// ignored. isDir tells whether name is a directory.
+func (r *ignoreRules) ignored(name string, isDir bool) bool {
+	name = strings.Trim(path.Clean(name), "/")
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		if r.match(parts[:i], true) {
+			return true
+		}
+	}
+	return r.match(parts, isDir)
+}
+
+func (r *ignoreRules) match(parts []string, isDir bool) bool {
+	ignored := false
+	for _, p := range r.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		matched := false
+		if p.anchored {
+			matched = matchSegments(p.segments, parts)
+		} else {
+			matched = matchSegments(p.segments, parts[len(parts)-1:])
+		}
+		if matched {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// matchSegments matches the segments of a path against the ones of a pattern, where "**"
+// matches any number of segments.
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	matched, err := path.Match(pattern[0], parts[0])
+	return err == nil && matched && matchSegments(pattern[1:], parts[1:])
+}
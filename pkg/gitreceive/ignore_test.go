@@ -0,0 +1,49 @@
+package gitreceive
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules, err := parseIgnoreRules(strings.NewReader(`
+# test fixtures and docs
+fixtures/
+/docs
+*.mp4
+!keep.mp4
+assets/**/*.psd
+\#notes
+build/
+!build/keep
+`))
+	assert.Nil(t, err)
+
+	cases := []struct {
+		name    string
+		isDir   bool
+		ignored bool
+	}{
+		{"fixtures", true, true},
+		{"fixtures/data.json", false, true},
+		{"test/fixtures/data.json", false, true},
+		{"fixtures", false, false},
+		{"docs/index.md", false, true},
+		{"web/docs/index.md", false, false},
+		{"video.mp4", false, true},
+		{"media/video.mp4", false, true},
+		{"media/keep.mp4", false, false},
+		{"assets/logo.psd", false, true},
+		{"assets/img/icons/logo.psd", false, true},
+		{"assets/logo.png", false, false},
+		{"#notes", false, true},
+		{"build/keep", false, true},
+		{"Procfile", false, false},
+		{"./Dockerfile", false, false},
+	}
+	for _, caze := range cases {
+		assert.Equal(t, rules.ignored(caze.name, caze.isDir), caze.ignored, "ignored %s", caze.name)
+	}
+}
@@ -2,9 +2,12 @@ package gitreceive
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/drycc/builder/pkg/k8s"
@@ -15,7 +18,6 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/util/wait"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
@@ -25,6 +27,9 @@ const (
 	sourceVersion          = "SOURCE_VERSION"
 	imagebuilderConfig     = "imagebuilder-config"
 	imagebuilderConfigPath = "/etc/imagebuilder"
+	// buildEnvGroup and buildEnvPrefix tell the config values of apps which go to builds
+	buildEnvGroup  = "build"
+	buildEnvPrefix = "BUILD_"
 )
 
 func imagebuilderJobName(appName, shortSha string) string {
@@ -40,21 +45,32 @@ func imagebuilderJobName(appName, shortSha string) string {
 func createBuilderJob(
 	debug bool,
 	name,
-	namespace string,
-	config []api.ConfigValue,
+	namespace,
+	envSecret,
 	tarKey,
 	gitShortHash string,
 	imageName,
-	builderName,
-	builderImage string,
+	builderName string,
+	stack Stack,
 	builderImageEnv map[string]string,
 	pullPolicy corev1.PullPolicy,
-	securityContext corev1.SecurityContext,
+	security Security,
 	nodeSelector map[string]string,
+	resources corev1.ResourceRequirements,
+	scheduling Scheduling,
+	identity Identity,
 ) *batchv1.Job {
-	job := buildJob(debug, name, namespace, builderName, pullPolicy, securityContext, nodeSelector, config)
+	job := buildJob(debug, name, namespace, builderName, pullPolicy, security.Context, nodeSelector, envSecret)
 	job.Spec.Template.Spec.Containers[0].Name = builderName
-	job.Spec.Template.Spec.Containers[0].Image = builderImage
+	job.Spec.Template.Spec.Containers[0].Image = stack.Image
+	job.Spec.Template.Spec.Containers[0].Resources = resources
+	identity.apply(&job.Spec.Template.Spec)
+	job.Spec.Template.Spec.HostUsers = security.HostUsers
+	// volumes, like build caches, belong to the group of containers which don't run as root
+	if security.Context.RunAsGroup != nil {
+		job.Spec.Template.Spec.SecurityContext = &corev1.PodSecurityContext{FSGroup: security.Context.RunAsGroup}
+	}
+	scheduling.apply(&job.Spec.Template.Spec, job.Spec.Template.Labels)
 
 	addEnvToJob(job, tarPath, tarKey)
 	addEnvToJob(job, sourceVersion, gitShortHash)
@@ -79,7 +95,7 @@ func buildJob(
 	pullPolicy corev1.PullPolicy,
 	securityContext corev1.SecurityContext,
 	nodeSelector map[string]string,
-	values []api.ConfigValue,
+	envSecret string,
 ) batchv1.Job {
 	TTLSecondsAfterFinished := newInt32(21600)
 	if os.Getenv("TTL_SECONDS_AFTER_FINISHED") != "" {
@@ -142,20 +158,13 @@ func buildJob(
 		ReadOnly:  true,
 	})
 
-	if len(job.Spec.Template.Spec.Containers) > 0 {
-		cvs := []api.ConfigVar{}
-		// only take the value of the global group
-		for _, v := range values {
-			if v.Group == "global" {
-				cvs = append(cvs, v.ConfigVar)
-			}
-		}
-		for _, v := range cvs {
-			job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
-				Name:  v.Name,
-				Value: fmt.Sprintf("%v", v.Value),
-			})
-		}
+	// the config of the app is in a secret, to stay out of the job spec
+	if envSecret != "" {
+		job.Spec.Template.Spec.Containers[0].EnvFrom = append(job.Spec.Template.Spec.Containers[0].EnvFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: envSecret},
+			},
+		})
 	}
 
 	if len(nodeSelector) > 0 {
@@ -178,31 +187,47 @@ func addEnvToJob(job batchv1.Job, key, value string) {
 	}
 }
 
-// waitForPod waits for a pod in state running, succeeded or failed
-func waitForPod(pw *k8s.PodWatcher, jobName string, ticker, interval, timeout time.Duration) error {
+// waitForPod waits for a pod in state running, succeeded or failed, telling the user what keeps it
+// from running with reporter, and giving up when it won't ever run.
+func waitForPod(pw *k8s.PodWatcher, reporter *podReporter, jobName string, ticker, timeout time.Duration) error {
 	condition := func(pod *corev1.Pod) (bool, error) {
+		if err := reporter.report(pod); err != nil {
+			return false, err
+		}
+		if pod == nil {
+			return false, nil
+		}
 		if pod.Status.Phase == corev1.PodRunning {
 			return true, nil
 		}
 		if pod.Status.Phase == corev1.PodSucceeded {
 			return true, nil
 		}
+		// pods failing before running, e.g. rejected by their node, end their attempt too, which
+		// the job may retry
 		if pod.Status.Phase == corev1.PodFailed {
-			return true, fmt.Errorf("giving up; pod went into failed status: \n[%s]:%s", pod.Status.Reason, pod.Status.Message)
+			return true, nil
 		}
 		return false, nil
 	}
 
 	quit := progress("...", ticker)
-	err := waitForPodCondition(pw, jobName, condition, interval, timeout)
+	err := waitForPodCondition(pw, jobName, condition, timeout)
 	quit <- true
 	<-quit
+	if errors.Is(err, errPodWaitTimeout) && reporter.last != "" {
+		return fmt.Errorf("%s, the last problem being: %s", err, reporter.last)
+	}
 	return err
 }
 
-// waitForPodEnd waits for a pod in state succeeded or failed
-func waitForPodEnd(pw *k8s.PodWatcher, jobName string, interval, timeout time.Duration) error {
+// waitForPodEnd waits for a pod in state succeeded or failed, or deleted, which happens when its
+// job fails
+func waitForPodEnd(pw *k8s.PodWatcher, jobName string, timeout time.Duration) error {
 	condition := func(pod *corev1.Pod) (bool, error) {
+		if pod == nil {
+			return true, nil
+		}
 		if pod.Status.Phase == corev1.PodSucceeded {
 			return true, nil
 		}
@@ -212,31 +237,58 @@ func waitForPodEnd(pw *k8s.PodWatcher, jobName string, interval, timeout time.Du
 		return false, nil
 	}
 
-	return waitForPodCondition(pw, jobName, condition, interval, timeout)
+	return waitForPodCondition(pw, jobName, condition, timeout)
+}
+
+// buildPodSelector selects the pods of the job named jobName.
+func buildPodSelector(jobName string) labels.Selector {
+	return labels.Set{
+		"job-name": jobName,
+		"heritage": "drycc",
+	}.AsSelector()
 }
 
-// waitForPodCondition waits for a pod in state defined by a condition (func)
+// errPodWaitTimeout is the error of waitForPodCondition when the pod isn't in the state it waits
+// for in time.
+var errPodWaitTimeout = errors.New("timed out")
+
+// latestBuildPod returns the last pod pw saw created by the job named jobName, which replaces the
+// ones of the builds it retries, and nil if there is none.
+func latestBuildPod(pw *k8s.PodWatcher, jobName string) *corev1.Pod {
+	pods, err := pw.Store.List(buildPodSelector(jobName))
+	if err != nil || len(pods) == 0 {
+		return nil
+	}
+	latest := pods[0]
+	for _, pod := range pods[1:] {
+		if latest.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			latest = pod
+		}
+	}
+	return latest
+}
+
+// waitForPodCondition waits for the latest pod of a job in state defined by a condition (func),
+// checking it every time pw sees the pod change. The pod is nil until it's created.
 func waitForPodCondition(pw *k8s.PodWatcher, jobName string, condition func(pod *corev1.Pod) (bool, error),
-	interval, timeout time.Duration,
+	timeout time.Duration,
 ) error {
-	return wait.PollUntilContextTimeout(context.Background(), interval, timeout, true, func(context.Context) (done bool, err error) {
-		selector := labels.Set{
-			"job-name": jobName,
-			"heritage": "drycc",
-		}.AsSelector()
-		pods, err := pw.Store.List(selector)
-		if err != nil || len(pods) == 0 {
-			return false, nil
-		}
-		done, err = condition(pods[0])
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		done, err := condition(latestBuildPod(pw, jobName))
 		if err != nil {
-			return false, err
+			return err
 		}
 		if done {
-			return true, nil
+			return nil
 		}
-		return false, nil
-	})
+		select {
+		case <-pw.Changed():
+		case <-timer.C:
+			return fmt.Errorf("%w after %s", errPodWaitTimeout, timeout)
+		}
+	}
 }
 
 func progress(msg string, interval time.Duration) chan bool {
@@ -259,6 +311,12 @@ func progress(msg string, interval time.Duration) chan bool {
 func createAppEnvConfigSecret(secretsClient typedcorev1.SecretInterface, secretName string, env map[string]any) error {
 	newSecret := new(corev1.Secret)
 	newSecret.Name = secretName
+	// the secrets left behind, e.g. by builders dying before their job owns them, are found by
+	// the name of their job
+	newSecret.Labels = map[string]string{
+		"job-name": secretName,
+		"heritage": "drycc",
+	}
 	newSecret.Type = corev1.SecretTypeOpaque
 	newSecret.Data = make(map[string][]byte)
 	for k, v := range env {
@@ -273,3 +331,69 @@ func createAppEnvConfigSecret(secretsClient typedcorev1.SecretInterface, secretN
 	}
 	return nil
 }
+
+// buildEnv returns the config of an app with values which builds get: the values of the build
+// group, and the values of the global group prefixed with buildEnvPrefix, without it. All of the
+// global group goes to builds too with global, as it used to.
+func buildEnv(values []api.ConfigValue, global bool) map[string]any {
+	env := make(map[string]any)
+	for _, v := range values {
+		if v.Group != "global" {
+			continue
+		}
+		if global {
+			env[v.Name] = v.Value
+		}
+		if name := strings.TrimPrefix(v.Name, buildEnvPrefix); name != v.Name && name != "" {
+			env[name] = v.Value
+		}
+	}
+	// the build group wins, being meant for builds only
+	for _, v := range values {
+		if v.Group == buildEnvGroup {
+			env[v.Name] = v.Value
+		}
+	}
+	return env
+}
+
+// ownSecret makes job the owner of the secret named secretName, so that it's deleted with job.
+func ownSecret(secretsClient typedcorev1.SecretInterface, secretName string, job *batchv1.Job) error {
+	secret, err := secretsClient.Get(context.TODO(), secretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	secret.OwnerReferences = append(secret.OwnerReferences, metav1.OwnerReference{
+		APIVersion: batchv1.SchemeGroupVersion.String(),
+		Kind:       "Job",
+		Name:       job.Name,
+		UID:        job.UID,
+	})
+	_, err = secretsClient.Update(context.TODO(), secret, metav1.UpdateOptions{})
+	return err
+}
+
+// deleteSecret deletes the secret named secretName, unless it's already deleted.
+func deleteSecret(secretsClient typedcorev1.SecretInterface, secretName string) error {
+	err := secretsClient.Delete(context.TODO(), secretName, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// sensitiveEnvName matches the names of env vars whose values are kept out of logs.
+var sensitiveEnvName = regexp.MustCompile(`(?i)(secret|password|passwd|token|key|credential|auth)`)
+
+// redactJob returns a copy of job without the values of sensitive env vars, for logging.
+func redactJob(job *batchv1.Job) *batchv1.Job {
+	redacted := job.DeepCopy()
+	for i := range redacted.Spec.Template.Spec.Containers {
+		for j, env := range redacted.Spec.Template.Spec.Containers[i].Env {
+			if env.Value != "" && sensitiveEnvName.MatchString(env.Name) {
+				redacted.Spec.Template.Spec.Containers[i].Env[j].Value = "REDACTED"
+			}
+		}
+	}
+	return redacted
+}
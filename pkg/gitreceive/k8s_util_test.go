@@ -1,10 +1,13 @@
 package gitreceive
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/drycc/builder/pkg/k8s"
 	"github.com/drycc/controller-sdk-go/api"
@@ -12,6 +15,10 @@ import (
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestImagebuilderPodName(t *testing.T) {
@@ -33,7 +40,7 @@ type imageBuildCase struct {
 	debug                       bool
 	name                        string
 	namespace                   string
-	env                         []api.ConfigValue
+	envSecret                   string
 	tarKey                      string
 	gitShortHash                string
 	imgName                     string
@@ -44,17 +51,6 @@ type imageBuildCase struct {
 }
 
 func TestBuildJob(t *testing.T) {
-	emptyValues := []api.ConfigValue{}
-
-	values := []api.ConfigValue{
-		{
-			Group: "global",
-			ConfigVar: api.ConfigVar{
-				Name:  "KEY",
-				Value: "VALUE",
-			},
-		},
-	}
 	var job *batchv1.Job
 
 	emptyNodeSelector := make(map[string]string)
@@ -67,13 +63,13 @@ func TestBuildJob(t *testing.T) {
 	nodeSelector2["network"] = "fast"
 
 	imageBuilds := []imageBuildCase{
-		{true, "test", "default", emptyValues, "tar", "deadbeef", "imagebuilder", "", "", corev1.PullAlways, nodeSelector1},
-		{true, "test", "default", values, "tar", "deadbeef", "", "imagebuilder", "", corev1.PullAlways, nodeSelector2},
-		{true, "test", "default", emptyValues, "tar", "deadbeef", "img", "imagebuilder", "", corev1.PullAlways, emptyNodeSelector},
-		{true, "test", "default", values, "tar", "deadbeef", "img", "imagebuilder", "", corev1.PullAlways, emptyNodeSelector},
-		{true, "test", "default", values, "tar", "deadbeef", "img", "imagebuilder", "customimage", corev1.PullAlways, emptyNodeSelector},
-		{true, "test", "default", values, "tar", "deadbeef", "img", "imagebuilder", "customimage", corev1.PullIfNotPresent, emptyNodeSelector},
-		{true, "test", "default", values, "tar", "deadbeef", "img", "imagebuilder", "customimage", corev1.PullNever, nil},
+		{true, "test", "default", "", "tar", "deadbeef", "imagebuilder", "", "", corev1.PullAlways, nodeSelector1},
+		{true, "test", "default", "test-env", "tar", "deadbeef", "", "imagebuilder", "", corev1.PullAlways, nodeSelector2},
+		{true, "test", "default", "", "tar", "deadbeef", "img", "imagebuilder", "", corev1.PullAlways, emptyNodeSelector},
+		{true, "test", "default", "test-env", "tar", "deadbeef", "img", "imagebuilder", "", corev1.PullAlways, emptyNodeSelector},
+		{true, "test", "default", "test-env", "tar", "deadbeef", "img", "imagebuilder", "customimage", corev1.PullAlways, emptyNodeSelector},
+		{true, "test", "default", "test-env", "tar", "deadbeef", "img", "imagebuilder", "customimage", corev1.PullIfNotPresent, emptyNodeSelector},
+		{true, "test", "default", "test-env", "tar", "deadbeef", "img", "imagebuilder", "customimage", corev1.PullNever, nil},
 	}
 	buildImageEnv := map[string]string{"DRYCC_REGISTRY_LOCATION": "on-cluster"}
 	for _, build := range imageBuilds {
@@ -81,16 +77,19 @@ func TestBuildJob(t *testing.T) {
 			build.debug,
 			build.name,
 			build.namespace,
-			build.env,
+			build.envSecret,
 			build.tarKey,
 			build.gitShortHash,
 			build.imgName,
 			build.imagebuilderName,
-			build.imagebuilderImage,
+			Stack{Name: "container", Image: build.imagebuilderImage},
 			buildImageEnv,
 			build.imagebuilderImagePullPolicy,
-			k8s.SecurityContextFromPrivileged(false),
+			Security{Context: k8s.SecurityContextFromPrivileged(false)},
 			build.builderPodNodeSelector,
+			corev1.ResourceRequirements{},
+			Scheduling{},
+			Identity{},
 		)
 
 		if job.Name != build.name {
@@ -117,12 +116,40 @@ func TestBuildJob(t *testing.T) {
 			}
 		}
 
+		if build.envSecret != "" {
+			assert.Equal(t, build.envSecret, job.Spec.Template.Spec.Containers[0].EnvFrom[0].SecretRef.Name, "env secret")
+		} else {
+			assert.Empty(t, job.Spec.Template.Spec.Containers[0].EnvFrom, "env secret")
+		}
+
 		if len(job.Spec.Template.Spec.NodeSelector) > 0 || len(build.builderPodNodeSelector) > 0 {
 			assert.Equal(t, job.Spec.Template.Spec.NodeSelector, build.builderPodNodeSelector, "node selector")
 		}
 	}
 }
 
+func TestCreateBuilderJobStack(t *testing.T) {
+	resources := corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("4Gi")},
+	}
+	stack := Stack{Name: "ko", Image: "ko"}
+	identity := Identity{ServiceAccount: "ko-builder", ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}}}
+	job := createBuilderJob(false, "test", "default", "", "tar", "deadbeef", "img", "imagebuilder",
+		stack, nil, corev1.PullAlways, Security{Context: k8s.SecurityContextFromPrivileged(false)}, nil, resources, Scheduling{}, identity)
+	assert.Equal(t, "ko", job.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, resources, job.Spec.Template.Spec.Containers[0].Resources)
+	assert.Equal(t, "ko-builder", job.Spec.Template.Spec.ServiceAccountName)
+	assert.Equal(t, identity.ImagePullSecrets, job.Spec.Template.Spec.ImagePullSecrets)
+	assert.Nil(t, job.Spec.Template.Spec.HostUsers)
+
+	hostUsers := false
+	security := Security{Profile: "rootless", Context: k8s.SecurityContextRootless(rootlessUID), HostUsers: &hostUsers}
+	job = createBuilderJob(false, "test", "default", "", "tar", "deadbeef", "img", "imagebuilder",
+		stack, nil, corev1.PullAlways, security, nil, resources, Scheduling{}, identity)
+	assert.Equal(t, &security.Context, job.Spec.Template.Spec.Containers[0].SecurityContext)
+	assert.Equal(t, &hostUsers, job.Spec.Template.Spec.HostUsers)
+}
+
 func checkForEnv(t *testing.T, job *batchv1.Job, key, expVal string) {
 	val, err := envValueFromKey(job, key)
 	if err != nil {
@@ -155,13 +182,16 @@ func TestCreateAppEnvConfigSecretErr(t *testing.T) {
 }
 
 func TestCreateAppEnvConfigSecretSuccess(t *testing.T) {
+	var created *corev1.Secret
 	secretsClient := &k8s.FakeSecret{
-		FnCreate: func(*corev1.Secret) (*corev1.Secret, error) {
+		FnCreate: func(secret *corev1.Secret) (*corev1.Secret, error) {
+			created = secret
 			return &corev1.Secret{}, nil
 		},
 	}
 	err := createAppEnvConfigSecret(secretsClient, "test", nil)
 	assert.Equal(t, err, nil)
+	assert.Equal(t, map[string]string{"job-name": "test", "heritage": "drycc"}, created.Labels)
 }
 
 func TestCreateAppEnvConfigSecretAlreadyExists(t *testing.T) {
@@ -177,3 +207,127 @@ func TestCreateAppEnvConfigSecretAlreadyExists(t *testing.T) {
 	err := createAppEnvConfigSecret(secretsClient, "test", nil)
 	assert.Equal(t, err, nil)
 }
+
+func TestBuildEnv(t *testing.T) {
+	values := []api.ConfigValue{
+		{Group: "global", ConfigVar: api.ConfigVar{Name: "DATABASE_URL", Value: "postgres://"}},
+		{Group: "global", ConfigVar: api.ConfigVar{Name: "BUILD_NPM_TOKEN", Value: "s3cr3t"}},
+		{Group: "global", ConfigVar: api.ConfigVar{Name: "BUILD_GOPROXY", Value: "https://proxy.golang.org"}},
+		{Group: "global", ConfigVar: api.ConfigVar{Name: "BUILD_", Value: "empty"}},
+		{Group: "build", ConfigVar: api.ConfigVar{Name: "GOPROXY", Value: "https://goproxy.internal"}},
+		{Group: "web", ConfigVar: api.ConfigVar{Name: "PORT", Value: 5000}},
+	}
+	assert.Equal(t, map[string]any{
+		"NPM_TOKEN": "s3cr3t",
+		"GOPROXY":   "https://goproxy.internal",
+	}, buildEnv(values, false), "build scoped values")
+
+	assert.Equal(t, map[string]any{
+		"DATABASE_URL":    "postgres://",
+		"BUILD_NPM_TOKEN": "s3cr3t",
+		"BUILD_GOPROXY":   "https://proxy.golang.org",
+		"BUILD_":          "empty",
+		"NPM_TOKEN":       "s3cr3t",
+		"GOPROXY":         "https://goproxy.internal",
+	}, buildEnv(values, true), "global values")
+}
+
+func TestOwnSecret(t *testing.T) {
+	var updated *corev1.Secret
+	secretsClient := &k8s.FakeSecret{
+		FnGet: func(name string) (*corev1.Secret, error) {
+			return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+		},
+		FnUpdate: func(secret *corev1.Secret) (*corev1.Secret, error) {
+			updated = secret
+			return secret, nil
+		},
+	}
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "test", UID: "1234"}}
+	assert.NoError(t, ownSecret(secretsClient, "test", job))
+	assert.Equal(t, []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: "test", UID: "1234"}}, updated.OwnerReferences)
+}
+
+func TestDeleteSecret(t *testing.T) {
+	secretsClient := &k8s.FakeSecret{
+		FnDelete: func(name string) error {
+			return apierrors.NewNotFound(corev1.Resource("secrets"), name)
+		},
+	}
+	assert.NoError(t, deleteSecret(secretsClient, "test"), "already deleted")
+
+	expectedErr := errors.New("delete secret error")
+	secretsClient.FnDelete = func(string) error { return expectedErr }
+	assert.Equal(t, expectedErr, deleteSecret(secretsClient, "test"))
+}
+
+func TestRedactJob(t *testing.T) {
+	job := createBuilderJob(false, "test", "default", "test", "tar", "deadbeef", "img", "imagebuilder",
+		Stack{}, map[string]string{"DRYCC_REGISTRY_PASSWORD": "s3cr3t", "DRYCC_STORAGE_ACCESSKEY": "AKIA"},
+		corev1.PullAlways, Security{}, nil, corev1.ResourceRequirements{}, Scheduling{}, Identity{})
+	redacted := redactJob(job)
+	checkForEnv(t, redacted, "DRYCC_REGISTRY_PASSWORD", "REDACTED")
+	checkForEnv(t, redacted, "DRYCC_STORAGE_ACCESSKEY", "REDACTED")
+	checkForEnv(t, redacted, "TAR_PATH", "tar")
+	checkForEnv(t, job, "DRYCC_REGISTRY_PASSWORD", "s3cr3t")
+}
+
+// watchJob returns the JobWatcher of the job named jobName of client, which follows it through a
+// BuildWatcher like builds do.
+func watchJob(t *testing.T, client kubernetes.Interface, jobName string) *k8s.JobWatcher {
+	bw := k8s.NewBuildWatcher(client, "default")
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	go bw.Run(stopCh)
+	socket := filepath.Join(t.TempDir(), "watcher.sock")
+	l, err := k8s.ListenBuildWatcher(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go bw.Serve(l)
+	jw, err := k8s.WatchJob(socket, jobName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(jw.Stop)
+	return jw
+}
+
+func TestWaitForPodEnd(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	pw := watchJob(t, client, "test").PodWatcher
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:   "test-1234",
+		Labels: map[string]string{"job-name": "test", "heritage": "drycc"},
+	}, Status: corev1.PodStatus{Phase: corev1.PodPending}}
+	other := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:   "other-1234",
+		Labels: map[string]string{"job-name": "other", "heritage": "drycc"},
+	}, Status: corev1.PodStatus{Phase: corev1.PodSucceeded}}
+	pods := client.CoreV1().Pods("default")
+	_, err := pods.Create(context.TODO(), pod, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	_, err = pods.Create(context.TODO(), other, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		_, exists, _ := pw.Store.Store.GetByKey("default/test-1234")
+		return exists
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Error(t, waitForPodEnd(pw, "test", 100*time.Millisecond), "pending pod")
+
+	go func() {
+		pod.Status.Phase = corev1.PodSucceeded
+		pods.UpdateStatus(context.TODO(), pod, metav1.UpdateOptions{})
+	}()
+	assert.NoError(t, waitForPodEnd(pw, "test", 10*time.Second))
+	assert.Len(t, pw.Store.Store.List(), 1, "only the pods of the job are watched")
+
+	assert.NoError(t, pods.Delete(context.TODO(), pod.Name, metav1.DeleteOptions{}))
+	assert.Eventually(t, func() bool {
+		return len(pw.Store.Store.List()) == 0
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.NoError(t, waitForPodEnd(pw, "test", 100*time.Millisecond), "deleted pod")
+}
@@ -0,0 +1,188 @@
+package gitreceive
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/drycc/builder/pkg/k8s"
+	"github.com/drycc/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// logTimestampsKey is the app config value printing the build logs with their timestamps when
+// it's "true".
+const logTimestampsKey = "DRYCC_BUILD_LOG_TIMESTAMPS"
+
+var (
+	// logReconnectWait is the time between two connections to the logs of a container.
+	logReconnectWait = time.Second
+	// maxLogReconnects is the number of times in a row the logs of a container are reconnected
+	// without getting new lines, before giving up.
+	maxLogReconnects = 10
+)
+
+// containerLogs streams the logs of the containers of a build pod, reconnecting when the
+// connection drops before they end, without repeating lines.
+type containerLogs struct {
+	// open opens a stream of the timestamped logs of container, since since unless it's nil.
+	open func(container string, since *metav1.Time) (io.ReadCloser, error)
+	// state returns whether container started, and whether it ended, which it did if the pod did.
+	state func(container string) (started, ended bool)
+	out   io.Writer
+	// timestamps keeps the timestamps of the lines, and prefix prefixes them with their container.
+	timestamps, prefix bool
+}
+
+// streamPodLogs writes the logs of the init containers and of the containers of pod to out, one
+// container after another, until they end.
+func streamPodLogs(client kubernetes.Interface, pw *k8s.PodWatcher, pod *corev1.Pod, out io.Writer, timestamps bool) error {
+	pods := client.CoreV1().Pods(pod.Namespace)
+	var containers []string
+	for _, container := range pod.Spec.InitContainers {
+		containers = append(containers, container.Name)
+	}
+	for _, container := range pod.Spec.Containers {
+		containers = append(containers, container.Name)
+	}
+	logs := &containerLogs{
+		open: func(container string, since *metav1.Time) (io.ReadCloser, error) {
+			return pods.GetLogs(pod.Name, &corev1.PodLogOptions{
+				Container:  container,
+				Follow:     true,
+				Timestamps: true,
+				SinceTime:  since,
+			}).Stream(context.TODO())
+		},
+		state: func(container string) (bool, bool) {
+			obj, exists, err := pw.Store.Store.GetByKey(pod.Namespace + "/" + pod.Name)
+			if err != nil || !exists {
+				return false, true
+			}
+			return containerState(obj.(*corev1.Pod), container)
+		},
+		out:        out,
+		timestamps: timestamps,
+		prefix:     len(containers) > 1,
+	}
+	for _, container := range containers {
+		if err := logs.stream(container); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// containerState returns whether the container of pod named name started, and whether it ended,
+// which it did if pod did.
+func containerState(pod *corev1.Pod, name string) (started, ended bool) {
+	podEnded := pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.Name != name {
+			continue
+		}
+		started = status.State.Running != nil || status.State.Terminated != nil || status.LastTerminationState.Terminated != nil
+		return started, podEnded || status.State.Terminated != nil
+	}
+	return false, podEnded
+}
+
+// stream writes the logs of container to out until it ends.
+func (l *containerLogs) stream(container string) error {
+	// last is the time of the last line written, and seen the number of lines written at that time,
+	// which reconnections since last skip
+	var last time.Time
+	seen := 0
+	failures := 0
+	for {
+		started, ended := l.state(container)
+		if !started && ended {
+			log.Debug("Skipping the logs of %s, which didn't start", container)
+			return nil
+		}
+		var err error
+		if started {
+			var since *metav1.Time
+			if !last.IsZero() {
+				since = &metav1.Time{Time: last}
+			}
+			var rc io.ReadCloser
+			if rc, err = l.open(container, since); err == nil {
+				var written int
+				written, err = l.copy(container, rc, &last, &seen)
+				rc.Close()
+				if written > 0 {
+					failures = 0
+				}
+			}
+			if _, ended := l.state(container); err == nil && ended {
+				return nil
+			}
+			if failures++; failures > maxLogReconnects {
+				if err == nil {
+					err = errors.New("the stream ended")
+				}
+				return fmt.Errorf("streaming the logs of %s (%s)", container, err)
+			}
+			log.Debug("Reconnecting to the logs of %s (%v)", container, err)
+		}
+		time.Sleep(logReconnectWait)
+	}
+}
+
+// copy writes the complete lines of rc after the seen lines at last to out, updating last and
+// seen, and returns the number of lines it wrote.
+func (l *containerLogs) copy(container string, rc io.Reader, last *time.Time, seen *int) (int, error) {
+	reader := bufio.NewReader(rc)
+	written, skipped := 0, 0
+	for {
+		line, err := reader.ReadString('\n')
+		// a line cut by a dropped connection comes again with the next one, so lines without a
+		// newline are only written once the container ended, for the last line of its logs
+		complete := err == nil
+		if err == io.EOF && line != "" {
+			_, complete = l.state(container)
+		}
+		if complete {
+			stamp, text, _ := strings.Cut(line, " ")
+			if t, perr := time.Parse(time.RFC3339Nano, stamp); perr == nil {
+				if t.Before(*last) {
+					continue
+				}
+				if t.Equal(*last) && skipped < *seen {
+					skipped++
+					continue
+				}
+				if t.After(*last) {
+					*last, *seen = t, 0
+				}
+				*seen++
+				if l.timestamps {
+					text = line
+				}
+			} else {
+				text = line
+			}
+			if l.prefix {
+				text = "[" + container + "] " + text
+			}
+			if _, werr := io.WriteString(l.out, text); werr != nil {
+				return written, werr
+			}
+			written++
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package gitreceive
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// droppedReader returns the content of a reader, then err instead of io.EOF.
+type droppedReader struct {
+	io.Reader
+	err error
+}
+
+func (r droppedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+func TestContainerLogsReconnect(t *testing.T) {
+	logReconnectWait = time.Millisecond
+	lines := []string{
+		"2024-01-01T00:00:01.000000000Z one\n",
+		"2024-01-01T00:00:02.000000000Z two\n",
+		"2024-01-01T00:00:02.000000000Z three\n",
+		"2024-01-01T00:00:03.000000000Z four\n",
+	}
+	var sinces []*metav1.Time
+	ended := false
+	out := &bytes.Buffer{}
+	logs := &containerLogs{
+		open: func(container string, since *metav1.Time) (io.ReadCloser, error) {
+			sinces = append(sinces, since)
+			if len(sinces) == 1 {
+				// the connection drops in the middle of the third line
+				content := strings.Join(lines[:2], "") + lines[2][:10]
+				return io.NopCloser(droppedReader{strings.NewReader(content), errors.New("connection reset")}), nil
+			}
+			// the API server resends the lines since the second of since
+			ended = true
+			return io.NopCloser(strings.NewReader(strings.Join(lines[1:], ""))), nil
+		},
+		state: func(container string) (bool, bool) { return true, ended },
+		out:   out,
+	}
+	assert.NoError(t, logs.stream("drycc-builder"))
+	assert.Equal(t, "one\ntwo\nthree\nfour\n", out.String())
+	assert.Len(t, sinces, 2)
+	assert.Nil(t, sinces[0])
+	assert.Equal(t, "2024-01-01T00:00:02Z", sinces[1].UTC().Format(time.RFC3339))
+
+	out.Reset()
+	sinces = nil
+	logs.timestamps, logs.prefix = true, true
+	assert.NoError(t, logs.stream("drycc-builder"))
+	assert.Equal(t, "[drycc-builder] "+strings.Join(lines, "[drycc-builder] "), out.String())
+}
+
+func TestContainerLogsCutLine(t *testing.T) {
+	logReconnectWait = time.Millisecond
+	opened := 0
+	ended := false
+	out := &bytes.Buffer{}
+	logs := &containerLogs{
+		open: func(container string, since *metav1.Time) (io.ReadCloser, error) {
+			opened++
+			if opened == 1 {
+				// the stream ends in the middle of the second line, while the container runs
+				return io.NopCloser(strings.NewReader("2024-01-01T00:00:01.000000000Z one\n2024-01-01T00:00:02.000000000Z tw")), nil
+			}
+			// the last line of the logs of the container has no newline
+			ended = true
+			return io.NopCloser(strings.NewReader("2024-01-01T00:00:01.000000000Z one\n2024-01-01T00:00:02.000000000Z two\n2024-01-01T00:00:03.000000000Z three")), nil
+		},
+		state: func(container string) (bool, bool) { return true, ended },
+		out:   out,
+	}
+	assert.NoError(t, logs.stream("drycc-builder"))
+	assert.Equal(t, "one\ntwo\nthree", out.String())
+	assert.Equal(t, 2, opened)
+}
+
+func TestContainerLogsGiveUp(t *testing.T) {
+	logReconnectWait = time.Millisecond
+	opened := 0
+	logs := &containerLogs{
+		open: func(string, *metav1.Time) (io.ReadCloser, error) {
+			opened++
+			return nil, errors.New("connection refused")
+		},
+		state: func(string) (bool, bool) { return true, false },
+		out:   io.Discard,
+	}
+	err := logs.stream("drycc-builder")
+	assert.EqualError(t, err, "streaming the logs of drycc-builder (connection refused)")
+	assert.Equal(t, maxLogReconnects+1, opened)
+
+	logs.state = func(string) (bool, bool) { return false, true }
+	assert.NoError(t, logs.stream("drycc-builder"), "containers which didn't start are skipped")
+}
+
+func TestContainerState(t *testing.T) {
+	pod := &corev1.Pod{Status: corev1.PodStatus{
+		Phase: corev1.PodRunning,
+		InitContainerStatuses: []corev1.ContainerStatus{
+			{Name: "init", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}},
+		},
+		ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "build", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			{Name: "sidecar", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{}}},
+		},
+	}}
+	for _, c := range []struct {
+		name           string
+		started, ended bool
+	}{
+		{"init", true, true},
+		{"build", true, false},
+		{"sidecar", false, false},
+	} {
+		started, ended := containerState(pod, c.name)
+		assert.Equal(t, c.started, started, c.name)
+		assert.Equal(t, c.ended, ended, c.name)
+	}
+	pod.Status.Phase = corev1.PodFailed
+	_, ended := containerState(pod, "sidecar")
+	assert.True(t, ended, "containers end with the pod")
+}
@@ -0,0 +1,100 @@
+package gitreceive
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drycc/builder/pkg/k8s"
+	"github.com/drycc/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// jobFailureReasons are the reasons of the warning events of jobs which failed.
+var jobFailureReasons = map[string]bool{
+	deadlineExceeded:       true,
+	"BackoffLimitExceeded": true,
+	"PodFailurePolicy":     true,
+}
+
+// fatalJobEvent returns whether a warning event of a build job means its pod won't ever run, e.g.
+// because the pod security admission rejects it, or because the job failed.
+func fatalJobEvent(event *corev1.Event) bool {
+	if jobFailureReasons[event.Reason] {
+		return true
+	}
+	return event.Reason == "FailedCreate" && strings.Contains(event.Message, "violates PodSecurity")
+}
+
+// podReporter tells the user what keeps the pod of a build job from running: the warning events
+// of the job and of the pod, why the pod isn't scheduled and why its containers wait. Each
+// problem is told once.
+type podReporter struct {
+	jw      *k8s.JobWatcher
+	jobName string
+	told    map[string]bool
+	// last is the last problem told, which timeouts mention.
+	last string
+}
+
+// newPodReporter creates a podReporter for the job named jobName, which jw follows.
+func newPodReporter(jw *k8s.JobWatcher, jobName string) *podReporter {
+	return &podReporter{jw: jw, jobName: jobName, told: make(map[string]bool)}
+}
+
+// report tells the problems of the job and of pod, which is nil until it's created. It returns an
+// error if one of them means that pod won't ever run.
+func (r *podReporter) report(pod *corev1.Pod) error {
+	if err := r.reportEvents(r.jw.Events("Job", r.jobName), fatalJobEvent); err != nil {
+		return err
+	}
+	if pod == nil {
+		return nil
+	}
+	// the job replaces the pods of the builds it retries, whose events are their own
+	if err := r.reportEvents(r.jw.Events("Pod", pod.Name), nil); err != nil {
+		return err
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse && condition.Message != "" {
+			r.tell(fmt.Sprintf("The build pod isn't scheduled yet (%s): %s", condition.Reason, condition.Message))
+		}
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		waiting := status.State.Waiting
+		if waiting == nil || waiting.Reason == "" || waiting.Reason == "ContainerCreating" || waiting.Reason == "PodInitializing" {
+			continue
+		}
+		r.tell(fmt.Sprintf("The build container %s is waiting (%s): %s", status.Name, waiting.Reason, waiting.Message))
+		if failure := startFailure(status); failure != nil {
+			return failure
+		}
+	}
+	return nil
+}
+
+// reportEvents tells the warning events, and returns an error for the first one which is fatal,
+// if any.
+func (r *podReporter) reportEvents(events []*corev1.Event, fatal func(*corev1.Event) bool) error {
+	for _, event := range events {
+		if event.Type != corev1.EventTypeWarning {
+			continue
+		}
+		r.tell(fmt.Sprintf("The build %s reported %s: %s", strings.ToLower(event.InvolvedObject.Kind), event.Reason, event.Message))
+		if fatal != nil && fatal(event) {
+			return fmt.Errorf("the build %s can't run (%s): %s", strings.ToLower(event.InvolvedObject.Kind), event.Reason, event.Message)
+		}
+	}
+	return nil
+}
+
+// tell prints problem to the user, unless it already was.
+func (r *podReporter) tell(problem string) {
+	r.last = problem
+	if r.told[problem] {
+		return
+	}
+	r.told[problem] = true
+	log.Info("%s", problem)
+}
@@ -0,0 +1,99 @@
+package gitreceive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func waitingPod(reason, message string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "test-1234",
+			Labels: map[string]string{"job-name": "test", "heritage": "drycc"},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "drycc-builder",
+				Image: "registry.drycc.cc/drycc/imagebuilder:canary",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: message}},
+			}},
+		},
+	}
+}
+
+func TestPodReporterReport(t *testing.T) {
+	r := newPodReporter(watchJob(t, fake.NewSimpleClientset(), "test"), "test")
+
+	assert.NoError(t, r.report(nil))
+	assert.NoError(t, r.report(waitingPod("ContainerCreating", "")))
+	assert.Empty(t, r.last)
+
+	assert.NoError(t, r.report(waitingPod("ErrImagePull", "not found")), "the image pull is retried")
+	assert.Equal(t, "The build container drycc-builder is waiting (ErrImagePull): not found", r.last)
+
+	transient := `Back-off pulling image "registry.drycc.cc/drycc/imagebuilder:canary": ErrImagePull: 429 Too Many Requests`
+	assert.NoError(t, r.report(waitingPod("ImagePullBackOff", transient)), "the image pull may still succeed")
+	assert.Equal(t, "The build container drycc-builder is waiting (ImagePullBackOff): "+transient, r.last)
+
+	err := r.report(waitingPod("ImagePullBackOff", `Back-off pulling image "registry.drycc.cc/drycc/imagebuilder:canary": ErrImagePull: manifest unknown`))
+	if assert.IsType(t, &buildFailure{}, err) {
+		assert.Equal(t, failureImagePull, err.(*buildFailure).reason)
+		assert.Contains(t, err.Error(), "build image registry.drycc.cc/drycc/imagebuilder:canary can't be pulled (ImagePullBackOff): Back-off pulling image")
+	}
+	err = r.report(waitingPod("InvalidImageName", "couldn't parse image name"))
+	if assert.IsType(t, &buildFailure{}, err) {
+		assert.Equal(t, failureImagePull, err.(*buildFailure).reason)
+	}
+
+	pod := waitingPod("", "")
+	pod.Status.ContainerStatuses = nil
+	pod.Status.Conditions = []corev1.PodCondition{{
+		Type:    corev1.PodScheduled,
+		Status:  corev1.ConditionFalse,
+		Reason:  corev1.PodReasonUnschedulable,
+		Message: "0/3 nodes are available: 3 Insufficient memory.",
+	}}
+	assert.NoError(t, r.report(pod))
+	assert.Equal(t, "The build pod isn't scheduled yet (Unschedulable): 0/3 nodes are available: 3 Insufficient memory.", r.last)
+}
+
+func TestWaitForPodJobEvents(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	jw := watchJob(t, client, "test")
+	r := newPodReporter(jw, "test")
+
+	events := client.CoreV1().Events("default")
+	quota := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "test.1", CreationTimestamp: metav1.Unix(1, 0)},
+		InvolvedObject: corev1.ObjectReference{Kind: "Job", Name: "test"},
+		Type:           corev1.EventTypeWarning,
+		Reason:         "FailedCreate",
+		Message:        "Error creating: pods \"test-1234\" is forbidden: exceeded quota: builds",
+	}
+	_, err := events.Create(context.TODO(), quota, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return len(jw.Events("Job", "test")) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	err = waitForPod(jw.PodWatcher, r, "test", time.Second, 100*time.Millisecond)
+	assert.EqualError(t, err, "timed out after 100ms, the last problem being: The build job reported FailedCreate: "+quota.Message)
+
+	go func() {
+		events.Create(context.TODO(), &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "test.2", CreationTimestamp: metav1.Unix(2, 0)},
+			InvolvedObject: corev1.ObjectReference{Kind: "Job", Name: "test"},
+			Type:           corev1.EventTypeWarning,
+			Reason:         "FailedCreate",
+			Message:        "Error creating: pods \"test-1234\" is forbidden: violates PodSecurity \"restricted:latest\"",
+		}, metav1.CreateOptions{})
+	}()
+	err = waitForPod(jw.PodWatcher, r, "test", time.Second, 10*time.Second)
+	assert.ErrorContains(t, err, "the build job can't run (FailedCreate)")
+}
@@ -0,0 +1,86 @@
+package gitreceive
+
+import (
+	"fmt"
+
+	"github.com/drycc/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// resourceSetting is a request or a limit of the build pods, which defaults to a builder setting
+// and which apps override with a config value.
+type resourceSetting struct {
+	name  corev1.ResourceName
+	limit bool
+	// env is the builder setting, and key the config value of apps
+	env, key string
+	value    func(conf *Config) string
+}
+
+var resourceSettings = []resourceSetting{
+	{corev1.ResourceCPU, false, "BUILDER_POD_CPU_REQUEST", "DRYCC_BUILD_CPU_REQUEST",
+		func(conf *Config) string { return conf.BuilderPodCPURequest }},
+	{corev1.ResourceCPU, true, "BUILDER_POD_CPU_LIMIT", "DRYCC_BUILD_CPU_LIMIT",
+		func(conf *Config) string { return conf.BuilderPodCPULimit }},
+	{corev1.ResourceMemory, false, "BUILDER_POD_MEMORY_REQUEST", "DRYCC_BUILD_MEMORY_REQUEST",
+		func(conf *Config) string { return conf.BuilderPodMemoryRequest }},
+	{corev1.ResourceMemory, true, "BUILDER_POD_MEMORY_LIMIT", "DRYCC_BUILD_MEMORY_LIMIT",
+		func(conf *Config) string { return conf.BuilderPodMemoryLimit }},
+}
+
+// builderPodResources returns the resources of the pods building with stack for an app with the
+// global config values, which override the ones of stack, which override the ones of conf.
+func builderPodResources(conf *Config, stack Stack, values map[string]string) (corev1.ResourceRequirements, error) {
+	resources := corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+	for _, setting := range resourceSettings {
+		if err := setting.set(resources, setting.env, setting.value(conf)); err != nil {
+			return resources, err
+		}
+	}
+	if stack.Resources != nil {
+		for name, quantity := range stack.Resources.Requests {
+			resources.Requests[name] = quantity
+		}
+		for name, quantity := range stack.Resources.Limits {
+			resources.Limits[name] = quantity
+		}
+	}
+	for _, setting := range resourceSettings {
+		if err := setting.set(resources, setting.key, values[setting.key]); err != nil {
+			return resources, err
+		}
+	}
+
+	// requests can't exceed limits, which happens when they come from different places
+	for name, request := range resources.Requests {
+		if limit, ok := resources.Limits[name]; ok && request.Cmp(limit) > 0 {
+			log.Debug("Lowering the %s request %s to the limit %s", name, request.String(), limit.String())
+			resources.Requests[name] = limit
+		}
+	}
+	if len(resources.Requests) == 0 {
+		resources.Requests = nil
+	}
+	if len(resources.Limits) == 0 {
+		resources.Limits = nil
+	}
+	return resources, nil
+}
+
+// set sets s in resources to value, named name, unless it's empty.
+func (s resourceSetting) set(resources corev1.ResourceRequirements, name, value string) error {
+	if value == "" {
+		return nil
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %s (%s)", name, value, err)
+	}
+	if s.limit {
+		resources.Limits[s.name] = quantity
+	} else {
+		resources.Requests[s.name] = quantity
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package gitreceive
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestBuilderPodResources(t *testing.T) {
+	conf := &Config{BuilderPodCPURequest: "500m", BuilderPodMemoryRequest: "512Mi"}
+	resources, err := builderPodResources(conf, Stack{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[corev1.ResourceName]string{"cpu": "500m", "memory": "512Mi"}, quantities(resources.Requests))
+	assert.Nil(t, resources.Limits, "builder defaults")
+
+	stack := Stack{Resources: &corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory:           resource.MustParse("256Mi"),
+			corev1.ResourceEphemeralStorage: resource.MustParse("10Gi"),
+		},
+	}}
+	resources, err = builderPodResources(conf, stack, map[string]string{"DRYCC_BUILD_CPU_LIMIT": "2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[corev1.ResourceName]string{"cpu": "1", "memory": "256Mi"}, quantities(resources.Requests),
+		"memory request lowered to the limit")
+	assert.Equal(t, map[corev1.ResourceName]string{"cpu": "2", "memory": "256Mi", "ephemeral-storage": "10Gi"},
+		quantities(resources.Limits), "stack and app limits")
+
+	resources, err = builderPodResources(&Config{}, Stack{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, corev1.ResourceRequirements{}, resources, "no resources")
+
+	_, err = builderPodResources(conf, stack, map[string]string{"DRYCC_BUILD_MEMORY_REQUEST": "lots"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid DRYCC_BUILD_MEMORY_REQUEST lots")
+	}
+	_, err = builderPodResources(&Config{BuilderPodCPULimit: "x"}, Stack{}, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid BUILDER_POD_CPU_LIMIT x")
+	}
+}
+
+func quantities(list corev1.ResourceList) map[corev1.ResourceName]string {
+	strs := make(map[corev1.ResourceName]string, len(list))
+	for name, quantity := range list {
+		strs[name] = quantity.String()
+	}
+	return strs
+}
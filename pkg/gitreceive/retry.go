@@ -0,0 +1,165 @@
+package gitreceive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/drycc/builder/pkg/k8s"
+	"github.com/drycc/pkg/log"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	typedbatchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
+)
+
+// retryableExitCodes are the exit codes of the build containers which are worth retrying: the ones
+// of containers killed, e.g. for running out of memory.
+var retryableExitCodes = []int32{137}
+
+// retryPolicy tells which failed build pods their job replaces.
+type retryPolicy struct {
+	// backoffLimit is the number of times a build is retried after exiting with a retryable exit
+	// code. Builds whose pods are disrupted, e.g. evicted or lost with their node, are retried
+	// regardless, until the deadline of the job.
+	backoffLimit int32
+}
+
+// builderPodRetryPolicy returns the retry policy of build jobs.
+func builderPodRetryPolicy(conf *Config) (retryPolicy, error) {
+	if conf.BuilderPodBackoffLimit < 0 {
+		return retryPolicy{}, fmt.Errorf("invalid BUILDER_POD_BACKOFF_LIMIT %d", conf.BuilderPodBackoffLimit)
+	}
+	return retryPolicy{backoffLimit: conf.BuilderPodBackoffLimit}, nil
+}
+
+// apply sets p in job, along with a pod failure policy failing job right away when a build
+// exits with a code which isn't retryable.
+func (p retryPolicy) apply(job *batchv1.Job) {
+	job.Spec.BackoffLimit = newInt32(p.backoffLimit)
+	job.Spec.PodFailurePolicy = &batchv1.PodFailurePolicy{
+		Rules: []batchv1.PodFailurePolicyRule{
+			{
+				Action: batchv1.PodFailurePolicyActionIgnore,
+				OnPodConditions: []batchv1.PodFailurePolicyOnPodConditionsPattern{
+					{Type: corev1.DisruptionTarget, Status: corev1.ConditionTrue},
+				},
+			},
+			{
+				Action: batchv1.PodFailurePolicyActionFailJob,
+				OnExitCodes: &batchv1.PodFailurePolicyOnExitCodesRequirement{
+					Operator: batchv1.PodFailurePolicyOnExitCodesOpNotIn,
+					Values:   retryableExitCodes,
+				},
+			},
+		},
+	}
+}
+
+// waitForRetry waits for the job named jobName to replace its failed pod named failed, and returns
+// whether it did, or failed instead.
+func waitForRetry(pw *k8s.PodWatcher, jobs typedbatchv1.JobInterface, jobName, failed string, timeout time.Duration) (bool, error) {
+	retried := false
+	condition := func(pod *corev1.Pod) (bool, error) {
+		if pod != nil && pod.Name != failed {
+			retried = true
+			return true, nil
+		}
+		job, err := getJob(jobs, jobName)
+		if err != nil {
+			return false, err
+		}
+		return jobFailure(job) != "", nil
+	}
+	err := waitForPodCondition(pw, jobName, condition, timeout)
+	return retried, err
+}
+
+// followBuildJob follows the pods of the build job named jobName with jw, writing their logs to
+// out, until one of them succeeds or the job gives up. The job runs for up to deadline seconds, and
+// replaces the pods of the builds it retries, which are followed one after another, whether they
+// failed while running or before. started is called once the first pod runs, which may not be the
+// pod of the first attempt when that one failed before running.
+func followBuildJob(client kubernetes.Interface, conf *Config, jw *k8s.JobWatcher, jobName string, deadline int64,
+	out io.Writer, timestamps bool, started func(),
+) error {
+	jobs := client.BatchV1().Jobs(conf.PodNamespace)
+	pw := jw.PodWatcher
+	reporter := newPodReporter(jw, jobName)
+	ran := false
+	for attempt := 1; ; attempt++ {
+		if err := waitForPod(pw, reporter, jobName, conf.SessionIdleInterval(), conf.BuilderPodWaitDuration()); err != nil {
+			return buildTimedOut(jobs, jobName, deadline, fmt.Errorf("watching events for builder pod startup (%s)", err))
+		}
+		pod := latestBuildPod(pw, jobName)
+		if pod == nil {
+			return buildTimedOut(jobs, jobName, deadline, fmt.Errorf("the builder pod of %s is gone", jobName))
+		}
+		if !ran && podRan(pod) {
+			ran = true
+			started()
+		}
+
+		if err := streamPodLogs(client, pw, pod, out, timestamps); err != nil {
+			return buildTimedOut(jobs, jobName, deadline, fmt.Errorf("fetching builder logs (%s)", err))
+		}
+
+		log.Debug(
+			"Waiting for the %s/%s pod to end, for up to %s",
+			pod.Namespace,
+			pod.Name,
+			conf.BuilderPodWaitDuration(),
+		)
+		// check the state and exit code of the build pod.
+		// if the code is not 0 return error
+		if err := waitForPodEnd(pw, jobName, conf.BuilderPodWaitDuration()); err != nil {
+			return buildTimedOut(jobs, jobName, deadline, fmt.Errorf("error getting builder pod status (%s)", err))
+		}
+		if err := buildTimedOut(jobs, jobName, deadline, nil); err != nil {
+			return err
+		}
+		log.Debug("Done")
+		log.Debug("Checking for builder pod exit code")
+		var failure *buildFailure
+		buildPod, err := client.CoreV1().Pods(pod.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			failure = &buildFailure{reason: failurePod, message: fmt.Sprintf("the builder pod %s is gone", pod.Name)}
+		} else if err != nil {
+			return fmt.Errorf("error getting builder pod status (%s)", err)
+		} else {
+			failure = podFailure(buildPod)
+		}
+		if failure == nil {
+			log.Debug("Done")
+			return nil
+		}
+		log.Debug("The build pod %s failed (%s)", pod.Name, failure.reason)
+
+		retried, err := waitForRetry(pw, jobs, jobName, pod.Name, conf.BuilderPodWaitDuration())
+		if err != nil || !retried {
+			if attempt > 1 {
+				return buildTimedOut(jobs, jobName, deadline, fmt.Errorf("%s, on attempt %d", failure, attempt))
+			}
+			return buildTimedOut(jobs, jobName, deadline, failure)
+		}
+		log.Info("The build failed (%s), retrying it, attempt %d", failure, attempt+1)
+	}
+}
+
+// podRan returns whether pod runs or ran, as opposed to failing before any of its containers
+// started, e.g. when its node rejected it.
+func podRan(pod *corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodRunning || pod.Status.Phase == corev1.PodSucceeded {
+		return true
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Running != nil || status.State.Terminated != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package gitreceive
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestBuilderPodRetryPolicy(t *testing.T) {
+	policy, err := builderPodRetryPolicy(&Config{BuilderPodBackoffLimit: 2})
+	assert.NoError(t, err)
+	job := &batchv1.Job{}
+	policy.apply(job)
+	assert.Equal(t, int32(2), *job.Spec.BackoffLimit)
+	rules := job.Spec.PodFailurePolicy.Rules
+	if assert.Len(t, rules, 2) {
+		assert.Equal(t, batchv1.PodFailurePolicyActionIgnore, rules[0].Action, "disrupted pods are retried")
+		assert.Equal(t, corev1.DisruptionTarget, rules[0].OnPodConditions[0].Type)
+		assert.Equal(t, batchv1.PodFailurePolicyActionFailJob, rules[1].Action, "failed builds aren't")
+		assert.Equal(t, batchv1.PodFailurePolicyOnExitCodesOpNotIn, rules[1].OnExitCodes.Operator)
+		assert.Equal(t, []int32{137}, rules[1].OnExitCodes.Values)
+	}
+
+	_, err = builderPodRetryPolicy(&Config{BuilderPodBackoffLimit: -1})
+	assert.EqualError(t, err, "invalid BUILDER_POD_BACKOFF_LIMIT -1")
+}
+
+func TestWaitForRetry(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	pw := watchJob(t, client, "test").PodWatcher
+
+	jobs := client.BatchV1().Jobs("default")
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	_, err := jobs.Create(context.TODO(), job, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	pods := client.CoreV1().Pods("default")
+	newPod := func(name string, created int64) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Labels:            map[string]string{"job-name": "test", "heritage": "drycc"},
+			CreationTimestamp: metav1.Unix(created, 0),
+		}, Status: corev1.PodStatus{Phase: corev1.PodFailed}}
+	}
+	_, err = pods.Create(context.TODO(), newPod("test-1", 1), metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return latestBuildPod(pw, "test") != nil
+	}, 10*time.Second, 10*time.Millisecond)
+
+	_, err = waitForRetry(pw, jobs, "test", "test-1", 100*time.Millisecond)
+	assert.Error(t, err, "the job neither retried nor failed")
+
+	go func() {
+		pods.Create(context.TODO(), newPod("test-2", 2), metav1.CreateOptions{})
+	}()
+	retried, err := waitForRetry(pw, jobs, "test", "test-1", 10*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, retried)
+	assert.Equal(t, "test-2", latestBuildPod(pw, "test").Name)
+
+	job.Status.Conditions = []batchv1.JobCondition{{
+		Type:   batchv1.JobFailed,
+		Status: corev1.ConditionTrue,
+		Reason: "PodFailurePolicy",
+	}}
+	_, err = jobs.UpdateStatus(context.TODO(), job, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	retried, err = waitForRetry(pw, jobs, "test", "test-2", 10*time.Second)
+	assert.NoError(t, err)
+	assert.False(t, retried, "the job failed")
+}
+
+func TestFollowBuildJob(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	conf := &Config{PodNamespace: "default", SessionIdleIntervalMsec: 10000, BuilderPodWaitDurationMSec: 10000}
+	_, err := client.BatchV1().Jobs("default").Create(context.TODO(), &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "test"}}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	pods := client.CoreV1().Pods("default")
+	newPod := func(name string, created int64, phase corev1.PodPhase, state corev1.ContainerState) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "default",
+				Labels:            map[string]string{"job-name": "test", "heritage": "drycc"},
+				CreationTimestamp: metav1.Unix(created, 0),
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "drycc-builder"}}},
+			Status: corev1.PodStatus{
+				Phase:             phase,
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "drycc-builder", State: state}},
+			},
+		}
+	}
+	// the first pod fails before running, e.g. rejected by its node
+	failed := newPod("test-1", 1, corev1.PodFailed, corev1.ContainerState{
+		Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"},
+	})
+	failed.Status.Reason = "UnexpectedAdmissionError"
+	_, err = pods.Create(context.TODO(), failed, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	// the job retries the build in a pod which succeeds, once the failure of the first one is seen
+	var retry sync.Once
+	client.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if get, ok := action.(k8stesting.GetAction); ok && get.GetName() == "test-1" {
+			retry.Do(func() {
+				go pods.Create(context.TODO(), newPod("test-2", 2, corev1.PodSucceeded, corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{ExitCode: 0},
+				}), metav1.CreateOptions{})
+			})
+		}
+		return false, nil, nil
+	})
+
+	var starts []string
+	out := &bytes.Buffer{}
+	jw := watchJob(t, client, "test")
+	err = followBuildJob(client, conf, jw, "test", 0, out, false, func() {
+		starts = append(starts, latestBuildPod(jw.PodWatcher, "test").Name)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test-2"}, starts, "started once the second pod ran")
+	assert.Equal(t, "fake logs", out.String(), "logs of the second pod")
+}
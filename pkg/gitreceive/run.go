@@ -7,7 +7,9 @@ import (
 	"strings"
 
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/drycc/builder/pkg/audit"
 	"github.com/drycc/builder/pkg/k8s"
+	"github.com/drycc/builder/pkg/notify"
 	"github.com/drycc/builder/pkg/sys"
 	"github.com/drycc/pkg/log"
 )
@@ -30,6 +32,12 @@ func Run(conf *Config, env sys.Env, storageDriver storagedriver.StorageDriver) e
 		return fmt.Errorf("couldn't reach the api server (%s)", err)
 	}
 
+	auditor, err := newAuditor(conf, storageDriver)
+	if err != nil {
+		return err
+	}
+	notifier := notify.New(conf.Notify)
+
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -42,10 +50,45 @@ func Run(conf *Config, env sys.Env, storageDriver storagedriver.StorageDriver) e
 
 		// if we're processing a receive-pack on an existing repo, run a build
 		if strings.HasPrefix(conf.SSHOriginalCommand, "git-receive-pack") {
-			if err := build(conf, storageDriver, kubeClient, env, newRev); err != nil {
+			evt := auditEvent(conf, refName, oldRev, newRev)
+			evt.Type = audit.RefUpdated
+			auditor.Emit(evt)
+			if err := build(conf, storageDriver, kubeClient, env, auditor, notifier, newRev); err != nil {
+				evt.Type = audit.BuildFailed
+				evt.Error = err.Error()
+				auditor.Emit(evt)
 				return err
 			}
+			evt.Type = audit.BuildSucceeded
+			auditor.Emit(evt)
 		}
 	}
 	return scanner.Err()
 }
+
+// newAuditor returns the auditor configured by conf.
+func newAuditor(conf *Config, storageDriver storagedriver.StorageDriver) (*audit.Auditor, error) {
+	auditConf := conf.Audit
+	if auditConf.Output == audit.Stdout {
+		// stdout of the hook is relayed to the client, so audit to the container's instead
+		auditConf.Output = audit.ContainerStdout
+	}
+	return audit.New(auditConf, storageDriver)
+}
+
+// auditEvent returns an audit.Event describing the push of refName from oldRev to newRev.
+func auditEvent(conf *Config, refName, oldRev, newRev string) audit.Event {
+	evt := audit.Event{
+		User:        conf.Username,
+		Fingerprint: conf.Fingerprint,
+		App:         conf.App(),
+		Ref:         refName,
+		OldRev:      oldRev,
+		NewRev:      newRev,
+	}
+	// SSH_CONNECTION is "client-ip client-port server-ip server-port"
+	if fields := strings.Fields(conf.SSHConnection); len(fields) > 0 {
+		evt.RemoteAddr = fields[0]
+	}
+	return evt
+}
@@ -32,3 +32,22 @@ func TestRun(t *testing.T) {
 		t.Errorf("expected error to be nil, got %s", err)
 	}
 }
+
+func TestAuditEvent(t *testing.T) {
+	conf := &Config{
+		Repository:    "demo.git",
+		Username:      "drycc",
+		Fingerprint:   "aa:bb",
+		SSHConnection: "10.0.0.1 51234 10.0.0.2 2223",
+	}
+	evt := auditEvent(conf, "refs/heads/main", "oldrev", "newrev")
+	if evt.App != "demo" {
+		t.Errorf("expected app 'demo', got '%s'", evt.App)
+	}
+	if evt.RemoteAddr != "10.0.0.1" {
+		t.Errorf("expected remote address '10.0.0.1', got '%s'", evt.RemoteAddr)
+	}
+	if evt.Ref != "refs/heads/main" || evt.OldRev != "oldrev" || evt.NewRev != "newrev" {
+		t.Errorf("unexpected ref update %s %s..%s", evt.Ref, evt.OldRev, evt.NewRev)
+	}
+}
@@ -0,0 +1,95 @@
+package gitreceive
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Scheduling tells the nodes the build pods go to, beyond their node selector.
+type Scheduling struct {
+	Tolerations               []corev1.Toleration               `json:"tolerations,omitempty"`
+	Affinity                  *corev1.Affinity                  `json:"affinity,omitempty"`
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	PriorityClassName         string                            `json:"priorityClassName,omitempty"`
+}
+
+// validate returns an error, completing the sentence "stack <name> ...", if s is invalid.
+func (s Scheduling) validate() error {
+	if s.PriorityClassName != "" {
+		if errs := validation.IsDNS1123Subdomain(s.PriorityClassName); len(errs) > 0 {
+			return fmt.Errorf("has an invalid priorityClassName %q (%s)", s.PriorityClassName, strings.Join(errs, ", "))
+		}
+	}
+	for _, toleration := range s.Tolerations {
+		switch toleration.Operator {
+		case "", corev1.TolerationOpEqual:
+		case corev1.TolerationOpExists:
+			if toleration.Value != "" {
+				return fmt.Errorf("has a toleration of %s with operator Exists and a value", toleration.Key)
+			}
+		default:
+			return fmt.Errorf("has a toleration of %s with an invalid operator %s", toleration.Key, toleration.Operator)
+		}
+	}
+	return nil
+}
+
+// builderPodScheduling returns the scheduling of the pods building with stack. Its tolerations are
+// added to the ones of conf, and its other settings replace the ones of conf.
+func builderPodScheduling(conf *Config, stack Stack) (Scheduling, error) {
+	var scheduling Scheduling
+	settings := []struct {
+		env, value string
+		dst        any
+	}{
+		{"BUILDER_POD_TOLERATIONS", conf.BuilderPodTolerations, &scheduling.Tolerations},
+		{"BUILDER_POD_AFFINITY", conf.BuilderPodAffinity, &scheduling.Affinity},
+		{"BUILDER_POD_TOPOLOGY_SPREAD_CONSTRAINTS", conf.BuilderPodTopologySpreadConstraints, &scheduling.TopologySpreadConstraints},
+	}
+	for _, setting := range settings {
+		if setting.value == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(setting.value), setting.dst); err != nil {
+			return scheduling, fmt.Errorf("invalid %s (%s)", setting.env, err)
+		}
+	}
+	scheduling.PriorityClassName = conf.BuilderPodPriorityClassName
+	if err := scheduling.validate(); err != nil {
+		return scheduling, fmt.Errorf("the builder pod scheduling %s", err)
+	}
+
+	scheduling.Tolerations = append(scheduling.Tolerations, stack.Tolerations...)
+	if stack.Affinity != nil {
+		scheduling.Affinity = stack.Affinity
+	}
+	if len(stack.TopologySpreadConstraints) > 0 {
+		scheduling.TopologySpreadConstraints = stack.TopologySpreadConstraints
+	}
+	if stack.PriorityClassName != "" {
+		scheduling.PriorityClassName = stack.PriorityClassName
+	}
+	return scheduling, nil
+}
+
+// apply sets s in the pod spec of the pods labeled with labels. Topology spread constraints
+// without a label selector spread the pods with the same "app" label.
+func (s Scheduling) apply(spec *corev1.PodSpec, labels map[string]string) {
+	spec.Tolerations = s.Tolerations
+	spec.Affinity = s.Affinity
+	spec.PriorityClassName = s.PriorityClassName
+	spec.TopologySpreadConstraints = nil
+	for _, constraint := range s.TopologySpreadConstraints {
+		if constraint.LabelSelector == nil {
+			constraint.LabelSelector = &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": labels["app"]},
+			}
+		}
+		spec.TopologySpreadConstraints = append(spec.TopologySpreadConstraints, constraint)
+	}
+}
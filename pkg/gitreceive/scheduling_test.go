@@ -0,0 +1,67 @@
+package gitreceive
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuilderPodScheduling(t *testing.T) {
+	conf := &Config{
+		BuilderPodTolerations:               `[{"key": "spot", "operator": "Exists", "effect": "NoSchedule"}]`,
+		BuilderPodAffinity:                  `{"nodeAffinity": {"preferredDuringSchedulingIgnoredDuringExecution": [{"weight": 1, "preference": {"matchExpressions": [{"key": "pool", "operator": "In", "values": ["builds"]}]}}]}}`,
+		BuilderPodTopologySpreadConstraints: `[{"maxSkew": 1, "topologyKey": "kubernetes.io/hostname", "whenUnsatisfiable": "ScheduleAnyway"}]`,
+		BuilderPodPriorityClassName:         "builds",
+	}
+	scheduling, err := builderPodScheduling(conf, Stack{})
+	assert.NoError(t, err)
+	assert.Equal(t, []corev1.Toleration{{Key: "spot", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}}, scheduling.Tolerations)
+	assert.Equal(t, "pool", scheduling.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].Preference.MatchExpressions[0].Key)
+	assert.Equal(t, "kubernetes.io/hostname", scheduling.TopologySpreadConstraints[0].TopologyKey)
+	assert.Equal(t, "builds", scheduling.PriorityClassName)
+
+	stack := Stack{Scheduling: Scheduling{
+		Tolerations:       []corev1.Toleration{{Key: "gpu", Operator: corev1.TolerationOpEqual, Value: "true"}},
+		Affinity:          &corev1.Affinity{},
+		PriorityClassName: "gpu-builds",
+	}}
+	scheduling, err = builderPodScheduling(conf, stack)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"spot", "gpu"}, []string{scheduling.Tolerations[0].Key, scheduling.Tolerations[1].Key}, "tolerations of the builder and the stack")
+	assert.Equal(t, &corev1.Affinity{}, scheduling.Affinity, "affinity of the stack")
+	assert.Len(t, scheduling.TopologySpreadConstraints, 1, "topology spread constraints of the builder")
+	assert.Equal(t, "gpu-builds", scheduling.PriorityClassName, "priority class of the stack")
+
+	_, err = builderPodScheduling(&Config{BuilderPodAffinity: "{"}, Stack{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid BUILDER_POD_AFFINITY")
+	}
+	_, err = builderPodScheduling(&Config{BuilderPodPriorityClassName: "Builds"}, Stack{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "the builder pod scheduling has an invalid priorityClassName")
+	}
+	_, err = builderPodScheduling(&Config{BuilderPodTolerations: `[{"key": "spot", "operator": "Exists", "value": "true"}]`}, Stack{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "the builder pod scheduling has a toleration of spot with operator Exists and a value")
+	}
+}
+
+func TestSchedulingApply(t *testing.T) {
+	scheduling := Scheduling{
+		TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+			{MaxSkew: 1, TopologyKey: "kubernetes.io/hostname"},
+			{MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone", LabelSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"pool": "builds"},
+			}},
+		},
+		PriorityClassName: "builds",
+	}
+	spec := corev1.PodSpec{}
+	scheduling.apply(&spec, map[string]string{"app": "drycc-imagebuilder", "job-name": "test"})
+	assert.Equal(t, "builds", spec.PriorityClassName)
+	assert.Equal(t, map[string]string{"app": "drycc-imagebuilder"}, spec.TopologySpreadConstraints[0].LabelSelector.MatchLabels)
+	assert.Equal(t, map[string]string{"pool": "builds"}, spec.TopologySpreadConstraints[1].LabelSelector.MatchLabels)
+	assert.Nil(t, scheduling.TopologySpreadConstraints[0].LabelSelector, "scheduling is left unchanged")
+}
@@ -0,0 +1,81 @@
+package gitreceive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/drycc/builder/pkg/k8s"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The security profiles of the build pods.
+const (
+	// privilegedProfile runs privileged containers, which every stack supports
+	privilegedProfile = "privileged"
+	// rootlessProfile runs as rootlessUID in a user namespace, as the "restricted" Pod Security
+	// Standard requires
+	rootlessProfile = "rootless"
+	// customProfile runs with the securityContext of the stack, or BUILDER_POD_SECURITY_CONTEXT
+	customProfile = "custom"
+
+	rootlessUID        = 1000
+	securityProfileKey = "DRYCC_SECURITY_PROFILE"
+)
+
+// Security is how the build pods run.
+type Security struct {
+	Profile string
+	Context corev1.SecurityContext
+	// HostUsers is false to run the pods in a user namespace
+	HostUsers *bool
+}
+
+// validSecurityProfile returns an error, completing the sentence "stack <name> ...", if profile
+// and context can't be used together.
+func validSecurityProfile(profile string, context *corev1.SecurityContext) error {
+	switch profile {
+	case privilegedProfile, rootlessProfile:
+		if context != nil {
+			return fmt.Errorf("has a securityContext, which only the %s securityProfile uses", customProfile)
+		}
+	case customProfile:
+		if context == nil {
+			return fmt.Errorf("has the %s securityProfile without securityContext", customProfile)
+		}
+	case "":
+	default:
+		return fmt.Errorf("has an invalid securityProfile %s", profile)
+	}
+	return nil
+}
+
+// builderPodSecurity returns how the pods building with stack run, which is the security profile
+// of conf unless stack has one.
+func builderPodSecurity(conf *Config, stack Stack) (Security, error) {
+	profile, context := conf.BuilderPodSecurityProfile, (*corev1.SecurityContext)(nil)
+	if conf.BuilderPodSecurityContext != "" {
+		if err := json.Unmarshal([]byte(conf.BuilderPodSecurityContext), &context); err != nil {
+			return Security{}, fmt.Errorf("invalid BUILDER_POD_SECURITY_CONTEXT (%s)", err)
+		}
+	}
+	if stack.SecurityProfile != "" {
+		profile, context = stack.SecurityProfile, stack.SecurityContext
+	} else if stack.SecurityContext != nil {
+		profile, context = customProfile, stack.SecurityContext
+	}
+
+	switch profile {
+	case privilegedProfile, "":
+		return Security{Profile: privilegedProfile, Context: k8s.SecurityContextFromPrivileged(true)}, nil
+	case rootlessProfile:
+		hostUsers := false
+		return Security{Profile: profile, Context: k8s.SecurityContextRootless(rootlessUID), HostUsers: &hostUsers}, nil
+	case customProfile:
+		if context == nil {
+			return Security{}, errors.New("the custom security profile needs a securityContext in the stack, or BUILDER_POD_SECURITY_CONTEXT")
+		}
+		return Security{Profile: profile, Context: *context}, nil
+	}
+	return Security{}, fmt.Errorf("invalid security profile %s", profile)
+}
@@ -0,0 +1,51 @@
+package gitreceive
+
+import (
+	"testing"
+
+	"github.com/drycc/builder/pkg/k8s"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestBuilderPodSecurity(t *testing.T) {
+	security, err := builderPodSecurity(&Config{BuilderPodSecurityProfile: "privileged"}, Stack{})
+	assert.NoError(t, err)
+	assert.Equal(t, Security{Profile: "privileged", Context: k8s.SecurityContextFromPrivileged(true)}, security)
+
+	security, err = builderPodSecurity(&Config{BuilderPodSecurityProfile: "privileged"}, Stack{SecurityProfile: "rootless"})
+	assert.NoError(t, err)
+	assert.Equal(t, "rootless", security.Profile, "profile of the stack")
+	assert.Equal(t, k8s.SecurityContextRootless(rootlessUID), security.Context)
+	assert.False(t, *security.HostUsers, "user namespace")
+
+	user := int64(2000)
+	context := corev1.SecurityContext{RunAsUser: &user}
+	security, err = builderPodSecurity(&Config{BuilderPodSecurityProfile: "rootless"}, Stack{SecurityContext: &context})
+	assert.NoError(t, err)
+	assert.Equal(t, Security{Profile: "custom", Context: context}, security, "security context of the stack")
+
+	security, err = builderPodSecurity(&Config{
+		BuilderPodSecurityProfile: "custom",
+		BuilderPodSecurityContext: `{"runAsUser": 2000}`,
+	}, Stack{})
+	assert.NoError(t, err)
+	assert.Equal(t, Security{Profile: "custom", Context: context}, security, "BUILDER_POD_SECURITY_CONTEXT")
+
+	_, err = builderPodSecurity(&Config{BuilderPodSecurityProfile: "custom"}, Stack{})
+	assert.Error(t, err, "custom profile without security context")
+	_, err = builderPodSecurity(&Config{BuilderPodSecurityProfile: "unconfined"}, Stack{})
+	assert.Error(t, err, "invalid profile")
+	_, err = builderPodSecurity(&Config{BuilderPodSecurityProfile: "custom", BuilderPodSecurityContext: "{"}, Stack{})
+	assert.Error(t, err, "invalid BUILDER_POD_SECURITY_CONTEXT")
+}
+
+func TestValidSecurityProfile(t *testing.T) {
+	context := &corev1.SecurityContext{}
+	assert.NoError(t, validSecurityProfile("", nil))
+	assert.NoError(t, validSecurityProfile("rootless", nil))
+	assert.NoError(t, validSecurityProfile("custom", context))
+	assert.Error(t, validSecurityProfile("custom", nil))
+	assert.Error(t, validSecurityProfile("privileged", context))
+	assert.Error(t, validSecurityProfile("unconfined", nil))
+}
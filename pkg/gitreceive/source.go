@@ -0,0 +1,321 @@
+package gitreceive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/drycc/builder/pkg/git"
+)
+
+const (
+	// sourceDirKey is the app config value naming the directory of the source to build, for apps
+	// living in a subdirectory of a monorepo.
+	sourceDirKey = "DRYCC_SOURCE_DIR"
+	// submodulesKey is the app config value which, set to "true", includes git submodules in the
+	// source.
+	submodulesKey = "DRYCC_GIT_SUBMODULES"
+	// lfsURLKey is the app config value naming the Git LFS server to fetch the LFS objects of the
+	// source from.
+	lfsURLKey = "DRYCC_GIT_LFS_URL"
+)
+
+// sourceFiles are the files of a source which the builder itself inspects. Directories are
+// extracted along with their contents.
+var sourceFiles = []string{"Procfile", "Dockerfile", "project.toml", ".drycc", ignoreFile}
+
+// source is the code of an app to build.
+type source struct {
+	// kind tells where the source comes from, either "git" or "tar". It prefixes the version in
+	// image tags.
+	kind string
+	// version identifies the source: the sha of a git commit, or the digest of an uploaded tarball
+	version string
+	// shortVersion is the abbreviated version used in image tags, job names and storage keys
+	shortVersion string
+	// archive writes the gzipped tarball of the source to w
+	archive func(w io.Writer) error
+	// files gives access to the sourceFiles the builder inspects
+	files fs.FS
+	// subtree returns the source made of the dir subdirectory of this one, along with an id of
+	// its contents. It's nil for sources which can't be built from a subdirectory.
+	subtree func(dir string) (source, string, error)
+	// checkout returns the source checked out into a directory according to opts, along with the
+	// func removing it. It's nil for sources which aren't git commits.
+	checkout func(opts git.CheckoutOptions) (source, func(), error)
+}
+
+// key returns the storage key the tarball of s is uploaded to.
+func (s source) key(appName string) string {
+	pattern := GitKeyPattern
+	if s.kind == "tar" {
+		pattern = TarballKeyPattern
+	}
+	return fmt.Sprintf(TarKeyPattern, fmt.Sprintf(pattern, appName, s.shortVersion))
+}
+
+// imageName returns the name of the image s is built into.
+func (s source) imageName(appName string) string {
+	return fmt.Sprintf("%s:%s-%s", appName, s.kind, s.shortVersion)
+}
+
+// cleanSourceDir returns dir, a directory relative to the top of a source, cleaned. The top itself
+// is "".
+func cleanSourceDir(dir string) (string, error) {
+	dir = strings.Trim(path.Clean(dir), "/")
+	if dir == ".." || strings.HasPrefix(dir, "../") {
+		return "", fmt.Errorf("%s is out of the source", dir)
+	}
+	if dir == "." {
+		return "", nil
+	}
+	return dir, nil
+}
+
+// uploadSource streams the tarball of src to key in the object storage, so that it's never held
+// in memory nor on disk. Paths ignored by rules, if any, are left out of it. It returns the size
+// of the uploaded tarball and the number of paths left out.
+func uploadSource(storageDriver storagedriver.StorageDriver, key string, src source, rules *ignoreRules) (int64, int, error) {
+	ctx := context.Background()
+	fw, err := storageDriver.Writer(ctx, key, false)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pr, pw := io.Pipe()
+	archiveErrCh := make(chan error, 1)
+	go func() {
+		err := src.archive(pw)
+		pw.CloseWithError(err)
+		archiveErrCh <- err
+	}()
+
+	ignored := 0
+	if rules == nil {
+		_, err = io.Copy(fw, pr)
+	} else {
+		ignored, err = filterTarball(fw, pr, rules)
+	}
+	// stop the archive if the upload failed half way
+	pr.CloseWithError(err)
+	if archiveErr := <-archiveErrCh; archiveErr != nil {
+		err = archiveErr
+	}
+	if err != nil {
+		fw.Cancel(ctx)
+		fw.Close()
+		return 0, 0, err
+	}
+	if err := fw.Commit(ctx); err != nil {
+		fw.Close()
+		return 0, 0, err
+	}
+	return fw.Size(), ignored, fw.Close()
+}
+
+// filterTarball copies the gzipped tarball read from r to w, leaving out the paths ignored by
+// rules. It returns the number of paths left out.
+func filterTarball(w io.Writer, r io.Reader, rules *ignoreRules) (int, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("reading the source tarball (%s)", err)
+	}
+	defer gzr.Close()
+	gzw := gzip.NewWriter(w)
+	tr := tar.NewReader(gzr)
+	tw := tar.NewWriter(gzw)
+	ignored := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("reading the source tarball (%s)", err)
+		}
+		if hdr.Typeflag != tar.TypeXGlobalHeader && rules.ignored(hdr.Name, hdr.Typeflag == tar.TypeDir) {
+			ignored++
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return 0, err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return 0, err
+		}
+	}
+	// consume whatever follows the end of the tar stream, for the writer not to block
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return 0, err
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	return ignored, gzw.Close()
+}
+
+// readIgnoreRules returns the rules of the ignoreFile of files, nil if there is none.
+func readIgnoreRules(files fs.FS) (*ignoreRules, error) {
+	f, err := files.Open(ignoreFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error in reading %s (%s)", ignoreFile, err)
+	}
+	defer f.Close()
+	return parseIgnoreRules(f)
+}
+
+// formatSize returns size, a number of bytes, in a human readable form.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// archiveDir writes a gzipped tarball of the contents of dir to w, leaving out git metadata and
+// the paths for which skip returns true.
+func archiveDir(w io.Writer, dir string, skip func(name string, isDir bool) bool) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == ".git" {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			// the .git file of a submodule
+			return nil
+		}
+		name, err := filepath.Rel(dir, file)
+		if err != nil || name == "." {
+			return err
+		}
+		if skip(filepath.ToSlash(name), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("archiving %s (%s)", dir, err)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// extractSourceFiles reads the gzipped tarball r and extracts the sourceFiles it holds to dir.
+func extractSourceFiles(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("reading the source tarball (%s)", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading the source tarball (%s)", err)
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		// detection files may match paths out of dir, like ../package.json for */package.json
+		if !fs.ValidPath(name) || !isSourceFile(name) {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(tr, target); err != nil {
+				return fmt.Errorf("extracting %s (%s)", name, err)
+			}
+		}
+	}
+}
+
+// isSourceFile returns whether name, a clean path in a source tarball, is one of the sourceFiles,
+// is under one of them, or may be inspected to detect the stack.
+func isSourceFile(name string) bool {
+	if matchesDetectionFile(name) {
+		return true
+	}
+	for _, file := range sourceFiles {
+		if name == file || strings.HasPrefix(name, file+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func extractFile(r io.Reader, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
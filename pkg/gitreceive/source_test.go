@@ -0,0 +1,209 @@
+package gitreceive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/stretchr/testify/assert"
+)
+
+// sourceTarball returns a gzipped tarball holding files, a map of paths to contents.
+func sourceTarball(t *testing.T, files map[string]string) []byte {
+	b := new(bytes.Buffer)
+	gz := gzip.NewWriter(b)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		assert.Nil(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, gz.Close())
+	return b.Bytes()
+}
+
+// tarballEntries returns the contents, or link targets, of the entries of the gzipped tarball r.
+func tarballEntries(t *testing.T, r io.Reader) map[string]string {
+	gz, err := gzip.NewReader(r)
+	assert.Nil(t, err)
+	tr := tar.NewReader(gz)
+	entries := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		assert.Nil(t, err)
+		content, err := io.ReadAll(tr)
+		assert.Nil(t, err)
+		entries[hdr.Name] = string(content) + hdr.Linkname
+	}
+}
+
+func TestSourceNames(t *testing.T) {
+	git := source{kind: "git", shortVersion: "abcdef12"}
+	assert.Equal(t, git.key("demo"), "home/demo:git-abcdef12/tar", "git storage key")
+	assert.Equal(t, git.imageName("demo"), "demo:git-abcdef12", "git image name")
+
+	tar := source{kind: "tar", shortVersion: "ba7816bf"}
+	assert.Equal(t, tar.key("demo"), "home/demo:tar-ba7816bf/tar", "tarball storage key")
+	assert.Equal(t, tar.imageName("demo"), "demo:tar-ba7816bf", "tarball image name")
+}
+
+func TestExtractSourceFiles(t *testing.T) {
+	dir := t.TempDir()
+	tarball := sourceTarball(t, map[string]string{
+		"Procfile":           "web: ./server",
+		"./.drycc/config":    "config",
+		"Dockerfile.dev":     "FROM scratch",
+		"src/Dockerfile":     "FROM scratch",
+		".drycc/../escape":   "escape",
+		"../Procfile":        "escape",
+		"project.toml/child": "child",
+	})
+	assert.Nil(t, extractSourceFiles(bytes.NewReader(tarball), dir))
+
+	procfile, err := os.ReadFile(filepath.Join(dir, "Procfile"))
+	assert.Nil(t, err)
+	assert.Equal(t, string(procfile), "web: ./server", "Procfile")
+	config, err := os.ReadFile(filepath.Join(dir, ".drycc", "config"))
+	assert.Nil(t, err)
+	assert.Equal(t, string(config), "config", ".drycc/config")
+	for _, name := range []string{"Dockerfile.dev", "src", "escape"} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		assert.True(t, os.IsNotExist(err), "%s must not be extracted", name)
+	}
+	_, err = os.Stat(filepath.Join(filepath.Dir(dir), "Procfile"))
+	assert.True(t, os.IsNotExist(err), "paths out of the tarball must not be extracted")
+
+	assert.NotNil(t, extractSourceFiles(bytes.NewBufferString("not gzipped"), dir), "plain tarball")
+}
+
+func TestExtractSourceFilesOutOfDir(t *testing.T) {
+	stacks := Stacks
+	defer func() { Stacks = stacks }()
+	var err error
+	Stacks, err = parseStacks([]byte(`[{"name": "node", "image": "node", "detect": {"rules": [{"file": "*/package.json"}]}}]`))
+	assert.Nil(t, err)
+
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "source")
+	tarball := sourceTarball(t, map[string]string{
+		"web/package.json": "{}",
+		"../package.json":  "escape",
+	})
+	assert.Nil(t, extractSourceFiles(bytes.NewReader(tarball), dir))
+	_, err = os.Stat(filepath.Join(dir, "web", "package.json"))
+	assert.Nil(t, err, "detection file")
+	_, err = os.Stat(filepath.Join(parent, "package.json"))
+	assert.True(t, os.IsNotExist(err), "detection files out of the tarball must not be extracted")
+}
+
+func TestUploadSource(t *testing.T) {
+	storageDriver, err := factory.Create(context.Background(), "inmemory", nil)
+	assert.Nil(t, err)
+	tarball := sourceTarball(t, map[string]string{"Dockerfile": "FROM scratch", "docs/index.md": "docs"})
+	src := source{
+		archive: func(w io.Writer) error {
+			_, err := w.Write(tarball)
+			return err
+		},
+	}
+
+	size, ignored, err := uploadSource(storageDriver, "/home/demo/abcdef12/tar", src, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, size, int64(len(tarball)), "uploaded size")
+	assert.Equal(t, ignored, 0, "ignored paths")
+	uploaded, err := storageDriver.GetContent(context.Background(), "/home/demo/abcdef12/tar")
+	assert.Nil(t, err)
+	assert.Equal(t, uploaded, tarball, "uploaded tarball")
+
+	rules, err := parseIgnoreRules(strings.NewReader("docs/"))
+	assert.Nil(t, err)
+	size, ignored, err = uploadSource(storageDriver, "/home/demo/abcdef12/tar", src, rules)
+	assert.Nil(t, err)
+	assert.Equal(t, ignored, 1, "ignored paths")
+	uploaded, err = storageDriver.GetContent(context.Background(), "/home/demo/abcdef12/tar")
+	assert.Nil(t, err)
+	assert.Equal(t, size, int64(len(uploaded)), "uploaded size")
+	assert.Equal(t, tarballEntries(t, bytes.NewReader(uploaded)), map[string]string{"Dockerfile": "FROM scratch"})
+
+	src.archive = func(w io.Writer) error {
+		w.Write(tarball[:len(tarball)/2])
+		return errors.New("archive failed")
+	}
+	_, _, err = uploadSource(storageDriver, "/home/demo/12345678/tar", src, nil)
+	assert.NotNil(t, err, "failed archive")
+	_, err = storageDriver.GetContent(context.Background(), "/home/demo/12345678/tar")
+	assert.NotNil(t, err, "a failed archive must not be uploaded")
+}
+
+func TestReadIgnoreRules(t *testing.T) {
+	rules, err := readIgnoreRules(fstest.MapFS{})
+	assert.Nil(t, err)
+	assert.Nil(t, rules, "rules without a .dryccignore")
+
+	rules, err = readIgnoreRules(fstest.MapFS{".dryccignore": &fstest.MapFile{Data: []byte("*.md")}})
+	assert.Nil(t, err)
+	assert.True(t, rules.ignored("README.md", false), "README.md ignored")
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, formatSize(512), "512 B")
+	assert.Equal(t, formatSize(1536), "1.5 KiB")
+	assert.Equal(t, formatSize(42<<20), "42.0 MiB")
+	assert.Equal(t, formatSize(3<<30), "3.0 GiB")
+}
+
+func TestCleanSourceDir(t *testing.T) {
+	cases := map[string]string{
+		"":            "",
+		".":           "",
+		"/":           "",
+		"web":         "web",
+		"/web/":       "web",
+		"apps/./web":  "apps/web",
+		"apps/../web": "web",
+	}
+	for dir, expected := range cases {
+		cleaned, err := cleanSourceDir(dir)
+		assert.Nil(t, err, "clean %s", dir)
+		assert.Equal(t, cleaned, expected, "clean %s", dir)
+	}
+	for _, dir := range []string{"..", "../web", "web/../../api"} {
+		_, err := cleanSourceDir(dir)
+		assert.NotNil(t, err, "clean %s", dir)
+	}
+}
+
+func TestArchiveDir(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, ".git", "objects"), 0o755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "lib"), 0o755))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "Procfile"), []byte("web: ./server"), 0o644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "lib", ".git"), []byte("gitdir: ../.git/modules/lib"), 0o644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "lib", "lib.go"), []byte("package lib"), 0o644))
+	assert.Nil(t, os.Symlink("Procfile", filepath.Join(dir, "Procfile.link")))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "docs"), 0o755))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "docs", "index.md"), []byte("docs"), 0o644))
+
+	archive := new(bytes.Buffer)
+	assert.Nil(t, archiveDir(archive, dir, func(name string, isDir bool) bool { return name == "docs" && isDir }))
+	assert.Equal(t, tarballEntries(t, archive), map[string]string{
+		"Procfile":      "web: ./server",
+		"Procfile.link": "Procfile",
+		"lib/":          "",
+		"lib/lib.go":    "package lib",
+	})
+}
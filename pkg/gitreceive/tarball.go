@@ -0,0 +1,104 @@
+package gitreceive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/drycc/builder/pkg/audit"
+	"github.com/drycc/builder/pkg/k8s"
+	"github.com/drycc/builder/pkg/notify"
+	"github.com/drycc/builder/pkg/sys"
+	"github.com/drycc/pkg/log"
+)
+
+// tarballDigestIdx is the length of the digest of a tarball used in image tags and storage keys,
+// the same as the one of an abbreviated git sha.
+const tarballDigestIdx = 8
+
+// RunTarball builds and releases the uploaded, gzipped tarball at path. It's the counterpart of
+// Run for sources deployed without git, and is effectively the main for the tarball-receive
+// command. The hex encoded SHA-256 digest of the tarball stands in for the git sha.
+func RunTarball(conf *Config, env sys.Env, storageDriver storagedriver.StorageDriver, path string) error {
+	log.Debug("Running tarball receive")
+	kubeClient, err := k8s.NewInCluster()
+	if err != nil {
+		return fmt.Errorf("couldn't reach the api server (%s)", err)
+	}
+	auditor, err := newAuditor(conf, storageDriver)
+	if err != nil {
+		return err
+	}
+	notifier := notify.New(conf.Notify)
+
+	digest, err := fileDigest(path)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp(conf.GitHome, conf.App()+"-tarball")
+	if err != nil {
+		return fmt.Errorf("unable to create tmpdir %s (%s)", conf.GitHome, err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			log.Info("unable to remove tmpdir %s (%s)", tmpDir, err)
+		}
+	}()
+	if err := extractTarballSourceFiles(path, tmpDir); err != nil {
+		return err
+	}
+
+	evt := auditEvent(conf, "", "", digest)
+	err = buildSource(conf, storageDriver, kubeClient, env, auditor, notifier, source{
+		kind:         "tar",
+		version:      digest,
+		shortVersion: digest[:tarballDigestIdx],
+		archive: func(w io.Writer) error {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(w, f)
+			return err
+		},
+		files: os.DirFS(tmpDir),
+	})
+	if err != nil {
+		evt.Type = audit.BuildFailed
+		evt.Error = err.Error()
+		auditor.Emit(evt)
+		return err
+	}
+	evt.Type = audit.BuildSucceeded
+	auditor.Emit(evt)
+	return nil
+}
+
+// fileDigest returns the hex encoded SHA-256 digest of the contents of the file at path.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("opening %s (%s)", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("reading %s (%s)", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// extractTarballSourceFiles extracts the sourceFiles of the gzipped tarball at path to dir.
+func extractTarballSourceFiles(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening %s (%s)", path, err)
+	}
+	defer f.Close()
+	return extractSourceFiles(f, dir)
+}
@@ -0,0 +1,20 @@
+package gitreceive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileDigest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.tar.gz")
+	assert.Nil(t, os.WriteFile(path, []byte("abc"), 0o600))
+	digest, err := fileDigest(path)
+	assert.Nil(t, err)
+	assert.Equal(t, digest, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", "sha256 digest")
+
+	_, err = fileDigest(filepath.Join(t.TempDir(), "missing.tar.gz"))
+	assert.NotNil(t, err, "digest of a missing file")
+}
@@ -0,0 +1,368 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// buildPodSelector selects the pods of every build job.
+	buildPodSelector = "heritage=drycc,job-name"
+	// warningSelector selects the warning events, the only ones builds tell.
+	warningSelector = "type=Warning"
+
+	jobNameLabel   = "job-name"
+	podsByJob      = "job"
+	eventsByObject = "object"
+
+	// watchJobRetry is how long a JobWatcher waits before reconnecting to its BuildWatcher.
+	watchJobRetry = time.Second
+)
+
+// JobSnapshot is what a BuildWatcher knows of a build job: its pods, and the warning events of the
+// job and of its pods.
+type JobSnapshot struct {
+	Pods   []v1.Pod   `json:"pods"`
+	Events []v1.Event `json:"events"`
+}
+
+// BuildWatcher watches the pods of every build job of a namespace and the warning events of the
+// namespace, with an informer each for all the builds of the builder. Builds run in processes of
+// their own, which follow their job with WatchJob, through the unix socket BuildWatcher serves on.
+type BuildWatcher struct {
+	pods        cache.Indexer
+	events      cache.Indexer
+	controllers []cache.Controller
+
+	mu sync.Mutex
+	// subscribers are notified of the changes of the jobs they're keyed by.
+	subscribers map[string]map[chan struct{}]bool
+}
+
+// NewBuildWatcher creates a BuildWatcher of the build jobs of ns, which watches them once it runs.
+func NewBuildWatcher(c kubernetes.Interface, ns string) *BuildWatcher {
+	w := &BuildWatcher{subscribers: make(map[string]map[chan struct{}]bool)}
+
+	pods, podsController := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: cache.ToListWatcherWithWatchListSemantics(&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = buildPodSelector
+				return c.CoreV1().Pods(ns).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = buildPodSelector
+				return c.CoreV1().Pods(ns).Watch(context.TODO(), options)
+			},
+		}, c),
+		ObjectType: &v1.Pod{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    w.podChanged,
+			UpdateFunc: func(_, obj any) { w.podChanged(obj) },
+			DeleteFunc: w.podChanged,
+		},
+		Indexers: cache.Indexers{podsByJob: func(obj any) ([]string, error) {
+			return []string{obj.(*v1.Pod).Labels[jobNameLabel]}, nil
+		}},
+	})
+	events, eventsController := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: cache.ToListWatcherWithWatchListSemantics(&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = warningSelector
+				return c.CoreV1().Events(ns).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = warningSelector
+				return c.CoreV1().Events(ns).Watch(context.TODO(), options)
+			},
+		}, c),
+		ObjectType: &v1.Event{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    w.eventChanged,
+			UpdateFunc: func(_, obj any) { w.eventChanged(obj) },
+		},
+		Indexers: cache.Indexers{eventsByObject: func(obj any) ([]string, error) {
+			event := obj.(*v1.Event)
+			return []string{objectKey(event.InvolvedObject.Kind, event.InvolvedObject.Name)}, nil
+		}},
+	})
+	w.pods, w.events = pods.(cache.Indexer), events.(cache.Indexer)
+	w.controllers = []cache.Controller{podsController, eventsController}
+	return w
+}
+
+// objectKey is the key of the events of the object of kind named name.
+func objectKey(kind, name string) string {
+	return kind + "/" + name
+}
+
+// Run watches the build jobs until stopCh is closed.
+func (w *BuildWatcher) Run(stopCh <-chan struct{}) {
+	for _, controller := range w.controllers {
+		go controller.Run(stopCh)
+	}
+	<-stopCh
+}
+
+// HasSynced returns whether w listed the pods and the events it watches.
+func (w *BuildWatcher) HasSynced() bool {
+	for _, controller := range w.controllers {
+		if !controller.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot returns what w knows of the job named jobName.
+func (w *BuildWatcher) Snapshot(jobName string) JobSnapshot {
+	snapshot := JobSnapshot{Pods: []v1.Pod{}, Events: []v1.Event{}}
+	pods, _ := w.pods.ByIndex(podsByJob, jobName)
+	keys := []string{objectKey("Job", jobName)}
+	for _, obj := range pods {
+		pod := obj.(*v1.Pod)
+		snapshot.Pods = append(snapshot.Pods, *pod)
+		keys = append(keys, objectKey("Pod", pod.Name))
+	}
+	for _, key := range keys {
+		events, _ := w.events.ByIndex(eventsByObject, key)
+		for _, obj := range events {
+			snapshot.Events = append(snapshot.Events, *obj.(*v1.Event))
+		}
+	}
+	return snapshot
+}
+
+// subscribe returns a channel receiving a value when the job named jobName changed since the last
+// value was received, until cancel is called.
+func (w *BuildWatcher) subscribe(jobName string) (changed <-chan struct{}, cancel func()) {
+	ch := make(chan struct{}, 1)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.subscribers[jobName] == nil {
+		w.subscribers[jobName] = make(map[chan struct{}]bool)
+	}
+	w.subscribers[jobName][ch] = true
+	return ch, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subscribers[jobName], ch)
+		if len(w.subscribers[jobName]) == 0 {
+			delete(w.subscribers, jobName)
+		}
+	}
+}
+
+// notify notifies the subscribers of the job named jobName.
+func (w *BuildWatcher) notify(jobName string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.subscribers[jobName] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *BuildWatcher) podChanged(obj any) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if pod, ok := obj.(*v1.Pod); ok {
+		w.notify(pod.Labels[jobNameLabel])
+	}
+}
+
+func (w *BuildWatcher) eventChanged(obj any) {
+	event, ok := obj.(*v1.Event)
+	if !ok {
+		return
+	}
+	switch event.InvolvedObject.Kind {
+	case "Job":
+		w.notify(event.InvolvedObject.Name)
+	case "Pod":
+		key := event.InvolvedObject.Namespace + "/" + event.InvolvedObject.Name
+		if obj, exists, err := w.pods.GetByKey(key); err == nil && exists {
+			w.notify(obj.(*v1.Pod).Labels[jobNameLabel])
+		}
+	}
+}
+
+// ServeHTTP is the http.Handler interface implementation. GET /jobs/<name> streams the snapshots
+// of the job named name as JSON, one per line, the first one right away and the others as it
+// changes, until the request is over.
+func (w *BuildWatcher) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	jobName, ok := strings.CutPrefix(r.URL.Path, "/jobs/")
+	if !ok || jobName == "" || r.Method != http.MethodGet {
+		http.NotFound(rw, r)
+		return
+	}
+	changed, cancel := w.subscribe(jobName)
+	defer cancel()
+	if !cache.WaitForCacheSync(r.Context().Done(), w.HasSynced) {
+		return
+	}
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(rw)
+	for {
+		if err := encoder.Encode(w.Snapshot(jobName)); err != nil {
+			return
+		}
+		if flusher, ok := rw.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// ListenBuildWatcher listens on the unix socket at socket for the builds following their job, in
+// place of the socket a previous builder left.
+func ListenBuildWatcher(socket string) (net.Listener, error) {
+	if err := os.Remove(socket); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return net.Listen("unix", socket)
+}
+
+// Serve serves the builds following their job with WatchJob on l.
+func (w *BuildWatcher) Serve(l net.Listener) error {
+	srv := &http.Server{Handler: w, ReadHeaderTimeout: 10 * time.Second}
+	return srv.Serve(l)
+}
+
+// JobWatcher is what a build knows of its job, kept up to date by the BuildWatcher of the builder.
+// Changed receives a value when the pods or the events of the job changed.
+type JobWatcher struct {
+	*PodWatcher
+	events cache.Store
+	stop   context.CancelFunc
+}
+
+// WatchJob returns the JobWatcher of the job named jobName, which follows it through the
+// BuildWatcher serving on socket until it's stopped. It returns an error if the BuildWatcher
+// can't be reached, and reconnects to it if the connection drops later on.
+func WatchJob(socket, jobName string) (*JobWatcher, error) {
+	jw := &JobWatcher{
+		PodWatcher: &PodWatcher{
+			Store:   StoreToPodLister{cache.NewStore(cache.MetaNamespaceKeyFunc)},
+			changed: make(chan struct{}, 1),
+		},
+		events: cache.NewStore(cache.MetaNamespaceKeyFunc),
+	}
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}}
+	jobURL := "http://builder/jobs/" + url.PathEscape(jobName)
+
+	var ctx context.Context
+	ctx, jw.stop = context.WithCancel(context.Background())
+	res, err := jw.connect(ctx, httpClient, jobURL)
+	if err != nil {
+		jw.stop()
+		return nil, err
+	}
+	go func() {
+		for {
+			jw.follow(res)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(watchJobRetry):
+			}
+			if res, err = jw.connect(ctx, httpClient, jobURL); err != nil {
+				res = nil
+			}
+		}
+	}()
+	return jw, nil
+}
+
+// connect requests the snapshots of the job of jw at jobURL.
+func (jw *JobWatcher) connect(ctx context.Context, httpClient *http.Client, jobURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jobURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the build watcher (%s)", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("the build watcher replied %s", res.Status)
+	}
+	return res, nil
+}
+
+// follow keeps jw up to date with the snapshots of res, until it ends.
+func (jw *JobWatcher) follow(res *http.Response) {
+	if res == nil {
+		return
+	}
+	defer res.Body.Close()
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var snapshot JobSnapshot
+		if err := decoder.Decode(&snapshot); err != nil {
+			return
+		}
+		pods := make([]any, 0, len(snapshot.Pods))
+		for i := range snapshot.Pods {
+			pods = append(pods, &snapshot.Pods[i])
+		}
+		events := make([]any, 0, len(snapshot.Events))
+		for i := range snapshot.Events {
+			events = append(events, &snapshot.Events[i])
+		}
+		jw.Store.Replace(pods, "")
+		jw.events.Replace(events, "")
+		jw.Notify()
+	}
+}
+
+// Events returns the warning events of the object of kind named name, e.g. of the job or of one
+// of its pods, oldest first.
+func (jw *JobWatcher) Events(kind, name string) []*v1.Event {
+	var events []*v1.Event
+	for _, obj := range jw.events.List() {
+		event := obj.(*v1.Event)
+		if event.InvolvedObject.Kind == kind && event.InvolvedObject.Name == name {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].CreationTimestamp.Before(&events[j].CreationTimestamp)
+	})
+	return events
+}
+
+// Stop stops following the job.
+func (jw *JobWatcher) Stop() {
+	jw.stop()
+}
@@ -0,0 +1,26 @@
+package k8s
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PersistentVolumeClaimDeleter is a (k8s.io/client-go/kubernetes/typed/core/v1).PersistentVolumeClaimInterface
+// compatible interface which only has the Delete function. It's used in places that only need
+// Delete to make them easier to test.
+//
+// Example usage:
+//
+//	var pvcd PersistentVolumeClaimDeleter
+//	pvcd = kubeClient.CoreV1().PersistentVolumeClaims(namespace)
+type PersistentVolumeClaimDeleter interface {
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+}
+
+// PersistentVolumeClaimCreator is the same as PersistentVolumeClaimDeleter, with only the Create
+// function.
+type PersistentVolumeClaimCreator interface {
+	Create(ctx context.Context, claim *corev1.PersistentVolumeClaim, opts metav1.CreateOptions) (*corev1.PersistentVolumeClaim, error)
+}
@@ -18,6 +18,7 @@ type FakeSecret struct {
 	FnGet    func(string) (*corev1.Secret, error)
 	FnCreate func(*corev1.Secret) (*corev1.Secret, error)
 	FnUpdate func(*corev1.Secret) (*corev1.Secret, error)
+	FnDelete func(string) error
 }
 
 // Get is the interface definition.
@@ -26,8 +27,11 @@ func (f *FakeSecret) Get(_ context.Context, name string, _ metav1.GetOptions) (*
 }
 
 // Delete is the interface definition.
-func (f *FakeSecret) Delete(context.Context, string, metav1.DeleteOptions) error {
-	return nil
+func (f *FakeSecret) Delete(_ context.Context, name string, _ metav1.DeleteOptions) error {
+	if f.FnDelete == nil {
+		return nil
+	}
+	return f.FnDelete(name)
 }
 
 // Create is the interface definition.
@@ -41,3 +41,17 @@ func SecurityContextFromPrivileged(privileged bool) v1.SecurityContext {
 		Privileged: &privileged,
 	}
 }
+
+// SecurityContextRootless create api.SecurityContext running as uid without privileges, as the
+// "restricted" Pod Security Standard requires.
+func SecurityContextRootless(uid int64) v1.SecurityContext {
+	nonRoot, escalation := true, false
+	return v1.SecurityContext{
+		RunAsNonRoot:             &nonRoot,
+		RunAsUser:                &uid,
+		RunAsGroup:               &uid,
+		AllowPrivilegeEscalation: &escalation,
+		Capabilities:             &v1.Capabilities{Drop: []v1.Capability{"ALL"}},
+		SeccompProfile:           &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault},
+	}
+}
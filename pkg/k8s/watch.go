@@ -1,14 +1,8 @@
 package k8s
 
 import (
-	"context"
-
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/watch"
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -17,10 +11,24 @@ type StoreToPodLister struct {
 	cache.Store
 }
 
-// PodWatcher is a struct which holds the return values of (k8s.io/kubernetes/pkg/controller/framework).NewIndexerInformer together.
+// PodWatcher is a cache of pods, kept up to date by a JobWatcher, which tells when they change.
 type PodWatcher struct {
-	Store      StoreToPodLister
-	Controller cache.Controller
+	Store   StoreToPodLister
+	changed chan struct{}
+}
+
+// Changed returns a channel receiving a value when pods were added, updated or deleted since the
+// last value was received.
+func (pw *PodWatcher) Changed() <-chan struct{} {
+	return pw.changed
+}
+
+// Notify sends a value to Changed, unless one is waiting to be received.
+func (pw *PodWatcher) Notify() {
+	select {
+	case pw.changed <- struct{}{}:
+	default:
+	}
 }
 
 // List returns a list of pods that match the given label selector.
@@ -37,30 +45,3 @@ func (s *StoreToPodLister) List(selector labels.Selector) (pods []*v1.Pod, err e
 	}
 	return pods, nil
 }
-
-// NewPodWatcher creates a new BuildPodWatcher useful to list the pods using a cache which gets updated based on the watch func.
-func NewPodWatcher(c kubernetes.Clientset, ns string) *PodWatcher {
-	pw := &PodWatcher{}
-
-	pw.Store.Store, pw.Controller = cache.NewInformerWithOptions(cache.InformerOptions{
-		ListerWatcher: &cache.ListWatch{
-			ListFunc:  podListFunc(c, ns),
-			WatchFunc: podWatchFunc(c, ns),
-		},
-		ObjectType: &v1.Pod{},
-		Handler:    cache.ResourceEventHandlerFuncs{},
-	})
-	return pw
-}
-
-func podListFunc(c kubernetes.Clientset, ns string) func(options metav1.ListOptions) (runtime.Object, error) {
-	return func(metav1.ListOptions) (runtime.Object, error) {
-		return c.CoreV1().Pods(ns).List(context.TODO(), metav1.ListOptions{})
-	}
-}
-
-func podWatchFunc(c kubernetes.Clientset, ns string) func(options metav1.ListOptions) (watch.Interface, error) {
-	return func(metav1.ListOptions) (watch.Interface, error) {
-		return c.CoreV1().Pods(ns).Watch(context.TODO(), metav1.ListOptions{})
-	}
-}
@@ -0,0 +1,94 @@
+// Package notify tells external systems about the lifecycle of builds, so that they can react to
+// them without polling the controller.
+package notify
+
+import (
+	"sync"
+	"time"
+
+	"github.com/drycc/pkg/log"
+)
+
+// Stage is a step in the lifecycle of a build.
+type Stage string
+
+const (
+	// Queued is reported when the source has been uploaded and the build is about to be scheduled.
+	Queued Stage = "build.queued"
+	// Started is reported when the imagebuild pod has started running.
+	Started Stage = "build.started"
+	// Succeeded is reported when the image has been built.
+	Succeeded Stage = "build.succeeded"
+	// Failed is reported when the build or the release failed at any point, even before being
+	// queued, e.g. when the source or the stack is invalid.
+	Failed Stage = "build.failed"
+	// Released is reported when the controller has published a release of the built image.
+	Released Stage = "build.released"
+)
+
+// Notification describes a build at a given Stage.
+type Notification struct {
+	Stage   Stage     `json:"event"`
+	Time    time.Time `json:"time"`
+	App     string    `json:"app"`
+	Sha     string    `json:"sha"`
+	User    string    `json:"user"`
+	Image   string    `json:"image,omitempty"`
+	Job     string    `json:"job,omitempty"`
+	Stack   string    `json:"stack,omitempty"`
+	Release int       `json:"release,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Hook is a receiver of build notifications.
+type Hook interface {
+	Notify(n Notification) error
+}
+
+// Dispatcher delivers notifications to a set of hooks. A nil *Dispatcher is valid and drops
+// every notification.
+type Dispatcher struct {
+	hooks []Hook
+	now   func() time.Time
+}
+
+// NewDispatcher returns a Dispatcher delivering to hooks.
+func NewDispatcher(hooks ...Hook) *Dispatcher {
+	return &Dispatcher{hooks: hooks, now: time.Now}
+}
+
+// Add registers another hook with d.
+func (d *Dispatcher) Add(hook Hook) {
+	d.hooks = append(d.hooks, hook)
+}
+
+// With returns a new Dispatcher delivering to the hooks of d as well as hooks. d may be nil.
+func (d *Dispatcher) With(hooks ...Hook) *Dispatcher {
+	with := NewDispatcher(hooks...)
+	if d != nil {
+		with.hooks = append(append([]Hook{}, d.hooks...), hooks...)
+		with.now = d.now
+	}
+	return with
+}
+
+// Dispatch stamps n with the current time and delivers it to every hook concurrently, returning
+// once all of them are done. Failing hooks are logged, never returned, so that an unreachable
+// receiver can't fail a build.
+func (d *Dispatcher) Dispatch(n Notification) {
+	if d == nil || len(d.hooks) == 0 {
+		return
+	}
+	n.Time = d.now().UTC()
+	var wg sync.WaitGroup
+	for _, hook := range d.hooks {
+		wg.Add(1)
+		go func(hook Hook) {
+			defer wg.Done()
+			if err := hook.Notify(n); err != nil {
+				log.Err("delivering %s notification for %s (%s)", n.Stage, n.App, err)
+			}
+		}(hook)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingHook struct {
+	mu            sync.Mutex
+	notifications []Notification
+	err           error
+}
+
+func (h *recordingHook) Notify(n Notification) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.notifications = append(h.notifications, n)
+	return h.err
+}
+
+func TestDispatch(t *testing.T) {
+	failing := &recordingHook{err: errors.New("test error")}
+	hook := &recordingHook{}
+	d := NewDispatcher(failing, hook)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	d.now = func() time.Time { return now }
+
+	d.Dispatch(Notification{Stage: Queued, App: "demo"})
+	d.Dispatch(Notification{Stage: Released, App: "demo", Release: 2})
+
+	assert.Equal(t, len(failing.notifications), 2, "notifications to the failing hook")
+	assert.Equal(t, len(hook.notifications), 2, "notifications to the hook")
+	assert.Equal(t, hook.notifications[0].Stage, Queued, "first stage")
+	assert.Equal(t, hook.notifications[0].Time, now, "time")
+	assert.Equal(t, hook.notifications[1].Release, 2, "release")
+}
+
+func TestDispatchNil(_ *testing.T) {
+	var d *Dispatcher
+	d.Dispatch(Notification{Stage: Failed})
+}
+
+func TestNew(t *testing.T) {
+	d := New(Config{WebhookURLs: []string{"http://a", "", "http://b"}, WebhookTimeoutMSec: 10})
+	assert.Equal(t, len(d.hooks), 2, "number of hooks")
+}
+
+func TestWith(t *testing.T) {
+	var d *Dispatcher
+	hook := &recordingHook{}
+	d.With(hook).Dispatch(Notification{Stage: Started})
+	assert.Equal(t, len(hook.notifications), 1, "notifications through a nil dispatcher")
+
+	base := NewDispatcher(hook)
+	other := &recordingHook{}
+	base.With(other).Dispatch(Notification{Stage: Succeeded})
+	assert.Equal(t, len(hook.notifications), 2, "notifications to the base hook")
+	assert.Equal(t, len(other.notifications), 1, "notifications to the added hook")
+	assert.Equal(t, len(base.hooks), 1, "base dispatcher is left untouched")
+}
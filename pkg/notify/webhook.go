@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// EventHeader carries the Stage of the notification.
+	EventHeader = "X-Drycc-Event"
+	// DeliveryHeader carries a unique id for every delivery.
+	DeliveryHeader = "X-Drycc-Delivery"
+	// SignatureHeader carries "sha256=" followed by the hex encoded HMAC-SHA256 of the request
+	// body, keyed with the webhook secret.
+	SignatureHeader = "X-Drycc-Signature-256"
+)
+
+// Config is the envconfig (http://github.com/kelseyhightower/envconfig) compatible struct for
+// build lifecycle webhooks.
+type Config struct {
+	WebhookURLs        []string `envconfig:"BUILD_WEBHOOK_URLS" default:""`
+	WebhookSecret      string   `envconfig:"BUILD_WEBHOOK_SECRET" default:""`
+	WebhookTimeoutMSec int      `envconfig:"BUILD_WEBHOOK_TIMEOUT" default:"5000"`
+}
+
+// WebhookTimeout returns c.WebhookTimeoutMSec as a time.Duration.
+func (c Config) WebhookTimeout() time.Duration {
+	return time.Duration(c.WebhookTimeoutMSec) * time.Millisecond
+}
+
+// New returns a Dispatcher with a Webhook for every URL in c.
+func New(c Config) *Dispatcher {
+	d := NewDispatcher()
+	for _, url := range c.WebhookURLs {
+		if url != "" {
+			d.Add(NewWebhook(url, c.WebhookSecret, c.WebhookTimeout()))
+		}
+	}
+	return d
+}
+
+// Webhook is a Hook that POSTs notifications as JSON, signed with a shared secret.
+type Webhook struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+// NewWebhook returns a Webhook posting to url, signing with secret and giving up on a request
+// after timeout. Requests are left unsigned if secret is empty.
+func NewWebhook(url, secret string, timeout time.Duration) *Webhook {
+	return &Webhook{url: url, secret: []byte(secret), client: &http.Client{Timeout: timeout}}
+}
+
+// Sign returns the value of SignatureHeader for body signed with secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify is the Hook interface implementation.
+func (w *Webhook) Notify(n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "drycc-builder")
+	req.Header.Set(EventHeader, string(n.Stage))
+	req.Header.Set(DeliveryHeader, uuid.New().String())
+	if len(w.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(w.secret, body))
+	}
+	res, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting to %s (%s)", w.url, err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("posting to %s returned %s", w.url, res.Status)
+	}
+	return nil
+}
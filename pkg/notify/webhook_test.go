@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	// printf '{}' | openssl dgst -sha256 -hmac secret
+	expected := "sha256=77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13"
+	actual := Sign([]byte("secret"), []byte("{}"))
+	assert.Equal(t, actual, expected, "signature")
+	assert.NotEqual(t, actual, Sign([]byte("other"), []byte("{}")), "signature depends on the secret")
+}
+
+func TestWebhookNotify(t *testing.T) {
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	hook := NewWebhook(server.URL, "secret", time.Second)
+	n := Notification{Stage: Succeeded, App: "demo", Sha: "0462cef5812ce31fe12f25596ff68dc614c708af", User: "drycc", Image: "demo:git-0462cef5"}
+	assert.Nil(t, hook.Notify(n))
+
+	assert.Equal(t, header.Get(EventHeader), string(Succeeded), "event header")
+	assert.NotEqual(t, header.Get(DeliveryHeader), "", "delivery header")
+	assert.Equal(t, header.Get(SignatureHeader), Sign([]byte("secret"), body), "signature header")
+
+	received := Notification{}
+	assert.Nil(t, json.Unmarshal(body, &received))
+	assert.Equal(t, received, n, "posted notification")
+
+	unsigned := NewWebhook(server.URL, "", time.Second)
+	assert.Nil(t, unsigned.Notify(n))
+	assert.Equal(t, header.Get(SignatureHeader), "", "unsigned signature header")
+}
+
+func TestWebhookNotifyFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	assert.NotNil(t, NewWebhook(server.URL, "secret", time.Second).Notify(Notification{Stage: Failed}))
+}
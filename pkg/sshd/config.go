@@ -2,6 +2,8 @@ package sshd
 
 import (
 	"time"
+
+	"github.com/drycc/builder/pkg/audit"
 )
 
 // Config represents the required SSH server configuration.
@@ -9,11 +11,22 @@ type Config struct {
 	ControllerURL               string `envconfig:"DRYCC_CONTROLLER_URL" required:"true"`
 	SSHHostIP                   string `envconfig:"SSH_HOST_IP" default:"0.0.0.0" required:"true"`
 	SSHHostPort                 int    `envconfig:"SSH_HOST_PORT" default:"2223" required:"true"`
+	PodNamespace                string `envconfig:"POD_NAMESPACE" default:"drycc"`
 	HealthSrvPort               int    `envconfig:"HEALTH_SERVER_PORT" default:"8092"`
 	HealthSrvTestStorageRegion  string `envconfig:"STORAGE_REGION" default:"us-east-1"`
 	CleanerPollSleepDurationSec int    `envconfig:"CLEANER_POLL_SLEEP_DURATION_SEC" default:"5"`
+	StacksPollSleepDurationSec  int    `envconfig:"STACKS_POLL_SLEEP_DURATION_SEC" default:"10"`
 	ImagebuilderImagePullPolicy string `envconfig:"IMAGEBUILDER_IMAGE_PULL_POLICY" default:"Always"`
 	LockTimeout                 int    `envconfig:"GIT_LOCK_TIMEOUT" default:"10"`
+	GitHTTPPort                 int    `envconfig:"GIT_HTTP_PORT" default:"0"`
+	GitHTTPTLSCertFile          string `envconfig:"GIT_HTTP_TLS_CERT_FILE" default:""`
+	GitHTTPTLSKeyFile           string `envconfig:"GIT_HTTP_TLS_KEY_FILE" default:""`
+	TarballMaxSizeMB            int64  `envconfig:"TARBALL_MAX_SIZE" default:"1024"`
+	Audit                       audit.Config
+
+	// BuildWatcherSocket is the unix socket the builds, which run in processes of their own, get
+	// the pods and the events of their job from
+	BuildWatcherSocket string `envconfig:"BUILD_WATCHER_SOCKET" default:"/tmp/drycc-builder-watcher.sock"`
 }
 
 // CleanerPollSleepDuration returns c.CleanerPollSleepDurationSec as a time.Duration.
@@ -21,7 +34,17 @@ func (c Config) CleanerPollSleepDuration() time.Duration {
 	return time.Duration(c.CleanerPollSleepDurationSec) * time.Second
 }
 
+// StacksPollSleepDuration returns c.StacksPollSleepDurationSec as a time.Duration.
+func (c Config) StacksPollSleepDuration() time.Duration {
+	return time.Duration(c.StacksPollSleepDurationSec) * time.Second
+}
+
 // GitLockTimeout return LockTimeout in minutes
 func (c Config) GitLockTimeout() time.Duration {
 	return time.Duration(c.LockTimeout) * time.Minute
 }
+
+// TarballMaxSize returns TarballMaxSizeMB in bytes
+func (c Config) TarballMaxSize() int64 {
+	return c.TarballMaxSizeMB << 20
+}
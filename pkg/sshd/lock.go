@@ -7,7 +7,8 @@ import (
 	"time"
 )
 
-var errAlreadyLocked = errors.New("already locked")
+// ErrAlreadyLocked is returned by WrapInLock if another operation holds the lock of a repository.
+var ErrAlreadyLocked = errors.New("already locked")
 
 // RepositoryLock interface that allows the creation of a lock associated
 // with a repository name to avoid simultaneous git operations.
@@ -21,9 +22,10 @@ type RepositoryLock interface {
 	Timeout() time.Duration
 }
 
-func wrapInLock(lck RepositoryLock, repoName string, fn func() error) error {
+// WrapInLock runs fn while holding the lock of repoName, giving up once the timeout of lck expires.
+func WrapInLock(lck RepositoryLock, repoName string, fn func() error) error {
 	if err := lck.Lock(repoName); err != nil {
-		return errAlreadyLocked
+		return ErrAlreadyLocked
 	}
 	timer := time.NewTimer(lck.Timeout())
 	defer timer.Stop()
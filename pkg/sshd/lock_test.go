@@ -92,18 +92,18 @@ func TestDoubleLockUnlock(t *testing.T) {
 func TestWrapInLock(t *testing.T) {
 	const repoName = "repo"
 	lck := NewInMemoryRepositoryLock(100 * time.Second)
-	assert.Equal(t, wrapInLock(lck, repoName, func() error {
+	assert.Equal(t, WrapInLock(lck, repoName, func() error {
 		return nil
 	}), nil)
 	assert.Equal(t, lck.Lock(repoName), nil)
-	assert.Error(t, errAlreadyLocked, wrapInLock(lck, repoName, func() error {
+	assert.Error(t, ErrAlreadyLocked, WrapInLock(lck, repoName, func() error {
 		return errGitReceive
 	}))
-	assert.Error(t, errAlreadyLocked, wrapInLock(lck, repoName, func() error {
+	assert.Error(t, ErrAlreadyLocked, WrapInLock(lck, repoName, func() error {
 		return nil
 	}))
 	assert.Equal(t, lck.Unlock(repoName), nil)
-	assert.Equal(t, wrapInLock(lck, repoName, func() error {
+	assert.Equal(t, WrapInLock(lck, repoName, func() error {
 		return nil
 	}), nil)
 }
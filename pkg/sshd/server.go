@@ -11,6 +11,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/drycc/builder/pkg/audit"
 	"github.com/drycc/builder/pkg/controller"
 	"github.com/drycc/builder/pkg/git"
 	"github.com/drycc/controller-sdk-go/hooks"
@@ -35,8 +36,8 @@ var (
 	errDirCreatePerm = errors.New("empty repo name")
 )
 
-// AuthKey authenticates based on a public key.
-func AuthKey(key ssh.PublicKey, cnf *Config) (*ssh.Permissions, error) {
+// AuthKey authenticates based on a public key, recording the decision with auditor.
+func AuthKey(meta ssh.ConnMetadata, key ssh.PublicKey, cnf *Config, auditor *audit.Auditor) (*ssh.Permissions, error) {
 	log.Info("Starting ssh authentication")
 	client, err := controller.New(cnf.ControllerURL)
 	if err != nil {
@@ -44,15 +45,28 @@ func AuthKey(key ssh.PublicKey, cnf *Config) (*ssh.Permissions, error) {
 	}
 
 	fp := fingerprint(key)
+	remoteAddr := remoteHost(meta.RemoteAddr())
 
 	userInfo, err := hooks.UserFromKey(client, fp)
 	if controller.CheckAPICompat(client, err) != nil {
 		log.Info("Failed to authenticate user ssh key %s with the controller: %s", fp, err)
+		auditor.Emit(audit.Event{
+			Type:        audit.AuthDenied,
+			Fingerprint: fp,
+			RemoteAddr:  remoteAddr,
+			Error:       err.Error(),
+		})
 		return nil, err
 	}
 
 	apps := strings.Join(userInfo.Apps, ", ")
 	log.Debug("Key accepted for user %s.", userInfo.Username)
+	auditor.Emit(audit.Event{
+		Type:        audit.AuthAccepted,
+		User:        userInfo.Username,
+		Fingerprint: fp,
+		RemoteAddr:  remoteAddr,
+	})
 	perm := &ssh.Permissions{
 		Extensions: map[string]string{
 			"user":        userInfo.Username,
@@ -75,10 +89,10 @@ func AuthKey(key ssh.PublicKey, cnf *Config) (*ssh.Permissions, error) {
 // Returns:
 //
 //	An *ssh.ServerConfig
-func Configure(cnf *Config) (*ssh.ServerConfig, error) {
+func Configure(cnf *Config, auditor *audit.Auditor) (*ssh.ServerConfig, error) {
 	cfg := &ssh.ServerConfig{
-		PublicKeyCallback: func(_ ssh.ConnMetadata, k ssh.PublicKey) (*ssh.Permissions, error) {
-			return AuthKey(k, cnf)
+		PublicKeyCallback: func(meta ssh.ConnMetadata, k ssh.PublicKey) (*ssh.Permissions, error) {
+			return AuthKey(meta, k, cnf, auditor)
 		},
 	}
 	hostKeyTypes := []string{"rsa", "ecdsa"}
@@ -109,6 +123,7 @@ func Serve(
 	serverCircuit *Circuit,
 	gitHomeDir string,
 	concurrentPushLock RepositoryLock,
+	auditor *audit.Auditor,
 	addr, receivetype string,
 ) error {
 	listener, err := net.Listen("tcp", addr)
@@ -119,6 +134,7 @@ func Serve(
 	srv := &server{
 		gitHome:     gitHomeDir,
 		pushLock:    concurrentPushLock,
+		auditor:     auditor,
 		receivetype: receivetype,
 	}
 
@@ -133,6 +149,7 @@ func Serve(
 type server struct {
 	gitHome     string
 	pushLock    RepositoryLock
+	auditor     *audit.Auditor
 	receivetype string
 }
 
@@ -200,6 +217,18 @@ func sshConnection(conn net.Conn) string {
 	return fmt.Sprintf("%s %s %s %s", rhost, rport, lhost, lport)
 }
 
+// remoteHost returns the host part of addr, or addr itself if it has no port.
+func remoteHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
 func sendExitStatus(status uint32, channel ssh.Channel) error {
 	exit := struct{ Status uint32 }{uint32(status)}
 	_, err := channel.SendRequest("exit-status", false, ssh.Marshal(exit))
@@ -249,8 +278,8 @@ func (s *server) answer(channel ssh.Channel, requests <-chan *ssh.Request, conda
 					channel.Stderr().Write([]byte("No repo given"))
 					return err
 				}
-				wrapErr := wrapInLock(s.pushLock, repoName, s.runReceive(req, sshconn, channel, repoName, parts, condata))
-				if wrapErr == errAlreadyLocked {
+				wrapErr := WrapInLock(s.pushLock, repoName, s.runReceive(req, sshconn, channel, repoName, parts, condata))
+				if wrapErr == ErrAlreadyLocked {
 					log.Info(multiplePush)
 					// The error must be in git format
 					if pktErr := gitPktLine(channel, fmt.Sprintf("ERR %v\n", multiplePush)); pktErr != nil {
@@ -299,9 +328,22 @@ func (s *server) runReceive(
 ) func() error {
 	return func() error {
 		req.Reply(true, nil) // We processed. Yay.
+		evt := audit.Event{
+			User:        sshConn.Permissions.Extensions["user"],
+			Fingerprint: sshConn.Permissions.Extensions["fingerprint"],
+			RemoteAddr:  remoteHost(sshConn.RemoteAddr()),
+			App:         repoName,
+		}
 		if !strings.Contains(sshConn.Permissions.Extensions["apps"], repoName) {
+			evt.Type = audit.AuthDenied
+			evt.Error = errBuildAppPerm.Error()
+			s.auditor.Emit(evt)
 			return errBuildAppPerm
 		}
+		if parts[0] == "git-receive-pack" {
+			evt.Type = audit.PushStarted
+			s.auditor.Emit(evt)
+		}
 		repo := repoName + ".git"
 		recvErr := git.Receive(
 			repo,
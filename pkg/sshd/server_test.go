@@ -248,7 +248,7 @@ func runServer(
 	t *testing.T,
 ) {
 	go func() {
-		if err := Serve(config, c, gitHome, pushLock, testAddr, "mock"); err != nil {
+		if err := Serve(config, c, gitHome, pushLock, nil, testAddr, "mock"); err != nil {
 			t.Errorf("Failed serving with %s", err)
 		}
 	}()